@@ -0,0 +1,88 @@
+package qyt
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Aggregate evaluates the expression on each document of the matched files on
+// the matched refs, collects the results into one sequence, and writes the
+// result of evaluating reduceExp on that sequence. Each item is a mapping with
+// the result nodes in "values" and the "ref", "head", "filename", and
+// "documentIndex" they were read from. The "branch", "remote", "tag", and
+// "rev" keys are only set when the ref has them. Nothing is written when the
+// expression fails on any file.
+func Aggregate(out io.Writer, repo *git.Repository, yqExp, reduceExp string, refFilter RefFilter, fileFilter FileFilter, cache *ResultCache, jobs int, outputToJSON bool) error {
+	reduceExpression, err := yqlib.ExpressionParser.ParseExpression(reduceExp)
+	if err != nil {
+		return fmt.Errorf("failed to parse reduce expression: %s\n", err)
+	}
+
+	items := &yqlib.CandidateNode{Kind: yqlib.SequenceNode, Tag: "!!seq"}
+	var errs []error
+	for result, err := range Results(repo, yqExp, refFilter, fileFilter, cache, jobs) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		items.AddChild(aggregateItem(result))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	nodes := list.New()
+	nodes.PushBack(items)
+	reduced, err := yqlib.NewDataTreeNavigator().GetMatchingNodes(yqlib.Context{MatchingNodes: nodes}, reduceExpression)
+	if err != nil {
+		return fmt.Errorf("reduce expression failed: %w", err)
+	}
+
+	printer := yqlib.NewPrinter(newOutputEncoder(outputToJSON), yqlib.NewSinglePrinterWriter(out))
+	if err := printer.PrintResults(reduced.MatchingNodes); err != nil {
+		return fmt.Errorf("rendering result failed: %w", err)
+	}
+	return nil
+}
+
+// aggregateItem returns the mapping Aggregate collects for a result. The
+// result nodes are copied so cached nodes are not modified.
+func aggregateItem(result Result) *yqlib.CandidateNode {
+	item := &yqlib.CandidateNode{Kind: yqlib.MappingNode, Tag: "!!map"}
+	set := func(key string, value *yqlib.CandidateNode) {
+		item.AddKeyValueChild(&yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!str", Value: key}, value)
+	}
+	setString := func(key, value string) {
+		set(key, &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!str", Value: value})
+	}
+
+	names := newRefNames(result.Ref.Name())
+	setString("ref", result.Ref.Name().Short())
+	for _, field := range []struct{ key, value string }{
+		{"branch", names.Branch},
+		{"remote", names.Remote},
+		{"tag", names.Tag},
+		{"rev", names.Revision},
+	} {
+		if field.value != "" {
+			setString(field.key, field.value)
+		}
+	}
+	setString("head", result.Ref.Hash().String())
+	setString("filename", result.File)
+	set("documentIndex", &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!int", Value: strconv.Itoa(result.Document)})
+
+	values := &yqlib.CandidateNode{Kind: yqlib.SequenceNode, Tag: "!!seq"}
+	for _, node := range result.Nodes {
+		values.AddChild(node)
+	}
+	set("values", values)
+
+	return item
+}
@@ -0,0 +1,81 @@
+package qyt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	sig := someSignature()
+	commitOnBranch := func(branch, from string, files map[string]string) {
+		t.Helper()
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(from)}))
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Create: true, Branch: plumbing.NewBranchReferenceName(branch)}))
+		for name, contents := range files {
+			createFile(t, wt.Filesystem, name, contents)
+			_, addErr := wt.Add(name)
+			assert.NoError(t, addErr)
+		}
+		_, commitErr := wt.Commit("update "+branch, &git.CommitOptions{Author: &sig, Committer: &sig})
+		assert.NoError(t, commitErr)
+	}
+
+	createInitialCommitOnMain(t, wt)
+	commitOnBranch("rel/1", "main", map[string]string{"chart.yaml": "version: 3\nimages: [api:1, web:1]\n"})
+	commitOnBranch("rel/2", "main", map[string]string{"chart.yaml": "version: 7\nimages: [api:2]\n"})
+	commitOnBranch("rel/3", "main", map[string]string{"chart.yaml": "version: 5\nimages: [api:2, web:3]\n"})
+
+	refFilter := RefFilter{Branches: "^rel/"}
+	fileFilter := FileFilter{Pattern: `chart\.yaml`}
+
+	t.Run("maximum", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Aggregate(&out, repo, `.version`, `map(.values[]) | max`, refFilter, fileFilter, nil, 2, false)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "7\n", out.String())
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Aggregate(&out, repo, `.images[]`, `[.[].values[]] | unique`, refFilter, fileFilter, nil, 1, true)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.JSONEq(t, `["api:1", "web:1", "api:2", "web:3"]`, out.String())
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Aggregate(&out, repo, `.version`, `.[] | select(.values[0] > 4) | .branch + " " + .filename + " " + (.documentIndex | tostring)`, refFilter, fileFilter, nil, 1, false)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "rel/2 chart.yaml 0\nrel/3 chart.yaml 0\n", out.String())
+	})
+
+	t.Run("invalid reduce expression", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Aggregate(&out, repo, `.version`, `map(`, refFilter, fileFilter, nil, 1, false)
+		assert.Error(t, err)
+		assert.Empty(t, out.String())
+	})
+}
@@ -1,6 +1,7 @@
 package qyt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"path"
@@ -55,15 +56,14 @@ func TestApply_one_branch_create_feature_branch(t *testing.T) {
 		return
 	}
 
-	if !assert.NoError(t,
-		Apply(repo,
-			`.version = "2.0"`,
-			"main",
-			`.*/main\.yml`, "add version\n\nQuery: {{.Query}}\n", "version-",
-			signature,
-			testing.Verbose(), false,
-		),
-	) {
+	_, applyErr := Apply(repo,
+		`.version = "2.0"`,
+		RefFilter{Branches: "main"},
+		FileFilter{Pattern: `.*/main\.yml`}, "add version\n\nQuery: {{.Query}}\n", "version-",
+		signature, signature, nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) {
 		return
 	}
 
@@ -184,28 +184,26 @@ func TestApply_update_existing_branches(t *testing.T) {
 			return
 		}
 
-		if !assert.NoError(t,
-			Apply(repo,
-				fmt.Sprintf(`.version = %q`, v),
-				strings.ReplaceAll(b, ".", "\\."),
-				`.*/main\.yml`, "set version\n\nQuery: {{.Query}}\n", "",
-				signature,
-				testing.Verbose(), true,
-			),
-		) {
+		_, applyErr := Apply(repo,
+			fmt.Sprintf(`.version = %q`, v),
+			RefFilter{Branches: strings.ReplaceAll(b, ".", "\\.")},
+			FileFilter{Pattern: `.*/main\.yml`}, "set version\n\nQuery: {{.Query}}\n", "",
+			signature, signature, nil,
+			1, testing.Verbose(), true,
+		)
+		if !assert.NoError(t, applyErr) {
 			return
 		}
 	}
 
-	if !assert.NoError(t,
-		Apply(repo,
-			`.greeting = "¡Holla!"`,
-			regexp.MustCompile(`^((main)|(rel/\d+\.\d+))$`).String(),
-			`.*/main\.yml`, "set greeting\n\nQuery: {{.Query}}\n", "",
-			signature,
-			testing.Verbose(), true,
-		),
-	) {
+	_, applyErr := Apply(repo,
+		`.greeting = "¡Holla!"`,
+		RefFilter{Branches: regexp.MustCompile(`^((main)|(rel/\d+\.\d+))$`).String()},
+		FileFilter{Pattern: `.*/main\.yml`}, "set greeting\n\nQuery: {{.Query}}\n", "",
+		signature, signature, nil,
+		1, testing.Verbose(), true,
+	)
+	if !assert.NoError(t, applyErr) {
 		return
 	}
 
@@ -297,6 +295,188 @@ func TestApply_update_existing_branches(t *testing.T) {
 	assert.NoError(t, branchIterForEachErr)
 }
 
+func TestApply_tag_creates_branch(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	signature := someSignature()
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	createFile(t, wt.Filesystem, "data.yml", "---\nversion: \"1.0\"\n")
+	_, addErr := wt.Add("data.yml")
+	if !assert.NoError(t, addErr) {
+		return
+	}
+	commitHash, commitErr := wt.Commit("add data", &git.CommitOptions{Author: &signature, Committer: &signature})
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+	_, tagErr := repo.CreateTag("v1.0", commitHash, &git.CreateTagOptions{Tagger: &signature, Message: "release"})
+	if !assert.NoError(t, tagErr) {
+		return
+	}
+
+	_, applyErr := Apply(repo,
+		`.version = "1.0.1"`,
+		RefFilter{Tags: `^v1\.0$`},
+		FileFilter{Pattern: `data\.yml`}, "patch {{.Branch}}", "patch/",
+		signature, signature, nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) {
+		return
+	}
+
+	ref, refErr := repo.Reference(plumbing.NewBranchReferenceName("patch/v1.0"), true)
+	if !assert.NoError(t, refErr) {
+		return
+	}
+	commit, getCommitErr := repo.CommitObject(ref.Hash())
+	if !assert.NoError(t, getCommitErr) {
+		return
+	}
+	assert.Equal(t, "patch v1.0", commit.Message)
+	assert.Equal(t, []plumbing.Hash{commitHash}, commit.ParentHashes)
+
+	file, fileErr := commit.File("data.yml")
+	if !assert.NoError(t, fileErr) {
+		return
+	}
+	contents, contentsErr := file.Contents()
+	if !assert.NoError(t, contentsErr) {
+		return
+	}
+	assert.Contains(t, contents, "version: \"1.0.1\"")
+
+	t.Run("revision", func(t *testing.T) {
+		updates, applyErr := Apply(repo,
+			`.version = "1.0.2"`,
+			RefFilter{Revisions: []string{"HEAD~0"}},
+			FileFilter{Pattern: `data\.yml`}, "patch {{.Branch}}", "patch/",
+			signature, signature, nil,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+			return
+		}
+		assert.Equal(t, plumbing.NewBranchReferenceName("patch/"+commitHash.String()[:7]), updates[0].Name)
+	})
+}
+
+func TestApply_multiple_documents(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	signature := someSignature()
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	createFile(t, wt.Filesystem, "manifests.yml", "---\nkind: Deployment\n---\nkind: Service\n---\nkind: ConfigMap\n")
+	_, addErr := wt.Add("manifests.yml")
+	if !assert.NoError(t, addErr) {
+		return
+	}
+	_, commitErr := wt.Commit("add manifests", &git.CommitOptions{Author: &signature, Committer: &signature})
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+
+	_, applyErr := Apply(repo,
+		`.metadata.annotations.index = $documentIndex`,
+		RefFilter{Branches: "master"},
+		FileFilter{Pattern: `manifests\.yml`}, "annotate", "annotated-",
+		signature, signature, nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) {
+		return
+	}
+
+	ref, refErr := repo.Reference(plumbing.NewBranchReferenceName("annotated-master"), true)
+	if !assert.NoError(t, refErr) {
+		return
+	}
+	commit, getCommitErr := repo.CommitObject(ref.Hash())
+	if !assert.NoError(t, getCommitErr) {
+		return
+	}
+	file, fileErr := commit.File("manifests.yml")
+	if !assert.NoError(t, fileErr) {
+		return
+	}
+	contents, contentsErr := file.Contents()
+	if !assert.NoError(t, contentsErr) {
+		return
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(contents))
+	for index, kind := range []string{"Deployment", "Service", "ConfigMap"} {
+		var data struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Annotations struct {
+					Index int `yaml:"index"`
+				} `yaml:"annotations"`
+			} `yaml:"metadata"`
+		}
+		if !assert.NoError(t, dec.Decode(&data), "document %d", index) {
+			return
+		}
+		assert.Equal(t, kind, data.Kind)
+		assert.Equal(t, index, data.Metadata.Annotations.Index)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	createSomeFilesWithNameKey(t, repo, "", "foo")
+	createSomeFilesWithNameKey(t, repo, "b", "bar")
+
+	var out bytes.Buffer
+	if !assert.NoError(t,
+		ApplyDryRun(&out, repo,
+			`select(.name == "about bar").name = "bar"`,
+			RefFilter{Branches: ".*"},
+			FileFilter{Pattern: `.*\.yml`}, "rename", "qyt/",
+			someSignature(),
+			1, testing.Verbose(), false,
+		),
+	) {
+		return
+	}
+
+	assert.Contains(t, out.String(), "# qyt/b from b\n")
+	assert.Contains(t, out.String(), "diff --git a/bar.yml b/bar.yml\n")
+	assert.Contains(t, out.String(), "--- a/bar.yml\n+++ b/bar.yml\n")
+	assert.Contains(t, out.String(), "-name: about bar\n+name: bar\n")
+	assert.NotContains(t, out.String(), "foo.yml")
+	assert.True(t, strings.HasSuffix(out.String(), "# 1 branches would get commits\n#   b -> qyt/b (1 files)\n"), out.String())
+
+	_, refErr := repo.Reference(plumbing.NewBranchReferenceName("qyt/b"), false)
+	assert.ErrorIs(t, refErr, plumbing.ErrReferenceNotFound)
+}
+
 func createFile(t *testing.T, fs billy.Basic, path, contents string) {
 	t.Helper()
 
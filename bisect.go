@@ -0,0 +1,146 @@
+package qyt
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Bisect binary searches the first-parent history of each matched ref for
+// the first commit where the predicate expression evaluates to true. A commit
+// satisfies the predicate when the expression returns true for any matched
+// file. Like git bisect, it assumes the predicate stays true once it flips.
+func Bisect(out io.Writer, repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, verbose bool) error {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	refs, err := MatchingRefs(repo, refFilter, verbose)
+	if err != nil {
+		return fmt.Errorf("failed to match refs: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	for _, ref := range refs {
+		head, commitErr := commitForRef(repo, ref)
+		if commitErr != nil {
+			return commitErr
+		}
+
+		first, tested, bisectErr := BisectHistory(head, yqExpression, fp, fileFilter.Format, func(file *object.File) map[string]string {
+			return NewScope(ref, file)
+		})
+		if bisectErr != nil {
+			return fmt.Errorf("could not bisect %s: %s", ref.Name().Short(), bisectErr)
+		}
+
+		if verbose {
+			_, _ = fmt.Fprintf(out, "# \tevaluated %d commits on %q\n", tested, ref.Name().Short())
+		}
+
+		if first == nil {
+			_, _ = fmt.Fprintf(out, "%s: %s is not true at %s\n", ref.Name().Short(), yqExp, head.Hash)
+			continue
+		}
+
+		subject, _, _ := strings.Cut(first.Message, "\n")
+		_, _ = fmt.Fprintf(out, "%s: %s (%s <%s> %s) %s\n",
+			ref.Name().Short(), first.Hash,
+			first.Author.Name, first.Author.Email, first.Author.When.UTC().Format(time.RFC3339),
+			subject)
+	}
+
+	return nil
+}
+
+// BisectHistory returns the first commit on the first-parent history of head
+// where the predicate is true, and the number of commits it evaluated. It
+// returns a nil commit when the predicate is not true for head.
+func BisectHistory(head *object.Commit, exp *yqlib.ExpressionNode, filePattern *regexp.Regexp, inputFormat string, scope func(file *object.File) map[string]string) (*object.Commit, int, error) {
+	history := []*object.Commit{head}
+	for commit := head; commit.NumParents() > 0; {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, 0, err
+		}
+		history = append(history, parent)
+		commit = parent
+	}
+	slices.Reverse(history)
+
+	tested := 0
+	isTrue := func(commit *object.Commit) (bool, error) {
+		tested++
+		return predicateIsTrue(commit, exp, filePattern, inputFormat, scope)
+	}
+
+	good, bad := -1, len(history)-1
+	headIsTrue, err := isTrue(history[bad])
+	if err != nil || !headIsTrue {
+		return nil, tested, err
+	}
+
+	// invariant: the predicate is false at good (or good is before the
+	// first commit) and true at bad
+	for bad-good > 1 {
+		mid := good + (bad-good)/2
+		midIsTrue, err := isTrue(history[mid])
+		if err != nil {
+			return nil, tested, err
+		}
+		if midIsTrue {
+			bad = mid
+		} else {
+			good = mid
+		}
+	}
+
+	return history[bad], tested, nil
+}
+
+func predicateIsTrue(commit *object.Commit, exp *yqlib.ExpressionNode, filePattern *regexp.Regexp, inputFormat string, scope func(file *object.File) map[string]string) (bool, error) {
+	found := false
+	err := HandleMatchingFiles(commit, filePattern, func(file *object.File) error {
+		if found {
+			return nil
+		}
+
+		rc, readerErr := file.Reader()
+		if readerErr != nil {
+			return readerErr
+		}
+		defer func() {
+			_ = rc.Close()
+		}()
+
+		variables := scope(file)
+		variables["head"] = commit.Hash.String()
+
+		result, evalErr := EvaluateExpression(rc, exp, file.Name, inputFormat, variables)
+		if evalErr != nil {
+			return fmt.Errorf("could not apply yq operation to file %q on %s: %s", file.Name, commit.Hash, evalErr)
+		}
+
+		for el := result.Front(); el != nil; el = el.Next() {
+			node := el.Value.(*yqlib.CandidateNode)
+			if node.Tag == "!!bool" && node.Value == "true" {
+				found = true
+				break
+			}
+		}
+		return nil
+	})
+	return found, err
+}
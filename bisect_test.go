@@ -0,0 +1,70 @@
+package qyt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBisect(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	var hashes []string
+	for i, replicas := range []int{1, 2, 2, 3, 5, 4, 6, 8} {
+		createFile(t, wt.Filesystem, "deploy.yml", fmt.Sprintf("replicas: %d\n", replicas))
+		_, addErr := wt.Add("deploy.yml")
+		if !assert.NoError(t, addErr) {
+			return
+		}
+		signature := someSignature()
+		signature.When = signature.When.Add(time.Duration(i) * time.Hour)
+		hash, commitErr := wt.Commit(fmt.Sprintf("set replicas to %d", replicas), &git.CommitOptions{Author: &signature, Committer: &signature, AllowEmptyCommits: true})
+		if !assert.NoError(t, commitErr) {
+			return
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	t.Run("flips", func(t *testing.T) {
+		var out bytes.Buffer
+		bisectErr := Bisect(&out, repo, ".replicas > 3", RefFilter{Branches: "master"}, FileFilter{Pattern: `deploy\.yml`}, false)
+		if !assert.NoError(t, bisectErr) {
+			return
+		}
+		assert.Equal(t, "master: "+hashes[4]+" (christopher <christopher@exmaple.com> 2021-06-03T04:29:38Z) set replicas to 5\n", out.String())
+	})
+
+	t.Run("true since the first commit", func(t *testing.T) {
+		var out bytes.Buffer
+		bisectErr := Bisect(&out, repo, ".replicas > 0", RefFilter{Branches: "master"}, FileFilter{Pattern: `deploy\.yml`}, false)
+		if !assert.NoError(t, bisectErr) {
+			return
+		}
+		assert.Contains(t, out.String(), "master: "+hashes[0]+" ")
+	})
+
+	t.Run("never true", func(t *testing.T) {
+		var out bytes.Buffer
+		bisectErr := Bisect(&out, repo, ".replicas > 10", RefFilter{Branches: "master"}, FileFilter{Pattern: `deploy\.yml`}, false)
+		if !assert.NoError(t, bisectErr) {
+			return
+		}
+		assert.Equal(t, "master: .replicas > 10 is not true at "+hashes[7]+"\n", out.String())
+	})
+}
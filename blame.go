@@ -0,0 +1,297 @@
+package qyt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Blame reports, for each node the expression selects in the matched files
+// on the matched refs, the commit that last changed the node's value.
+//
+// Values are compared structurally, so reformatting a file, changing
+// comments, or reordering mapping keys does not count as a change.
+func Blame(out io.Writer, repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, verbose bool) error {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	refs, err := MatchingRefs(repo, refFilter, verbose)
+	if err != nil {
+		return fmt.Errorf("failed to match refs: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	return blame(out, repo, yqExpression, refs, fp, fileFilter.Format, verbose)
+}
+
+// BlameLine is the commit that last changed the value of the node at Path
+// in the document with index Document. Value is the node encoded as compact
+// JSON.
+type BlameLine struct {
+	Document int
+	Path     []any
+	Value    string
+	Commit   *object.Commit
+}
+
+func blame(out io.Writer, repo *git.Repository, exp *yqlib.ExpressionNode, refs []plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, verbose bool) error {
+	for _, ref := range refs {
+		if verbose {
+			_, _ = fmt.Fprintf(out, "# \tblaming files on %q\n", ref.Name().Short())
+		}
+
+		head, err := commitForRef(repo, ref)
+		if err != nil {
+			return err
+		}
+
+		err = HandleMatchingFiles(head, filePattern, func(file *object.File) error {
+			if verbose {
+				_, _ = fmt.Fprintf(out, "# \t\tmatched %q\n", file.Name)
+			}
+
+			lines, blameErr := BlameFile(head, file, exp, inputFormat, NewScope(ref, file))
+			if blameErr != nil {
+				return fmt.Errorf("could not blame file %q on %s: %s", file.Name, ref.Name(), blameErr)
+			}
+
+			for _, line := range lines {
+				location := file.Name
+				if line.Document > 0 {
+					location += fmt.Sprintf("#%d", line.Document)
+				}
+				_, _ = fmt.Fprintf(out, "%s (%s <%s> %s) %s %s %s: %s\n",
+					line.Commit.Hash,
+					line.Commit.Author.Name, line.Commit.Author.Email, line.Commit.Author.When.UTC().Format(time.RFC3339),
+					ref.Name().Short(), location, yqPath(line.Path), line.Value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlameFile evaluates the expression on file as it is in head and finds the
+// commit that last changed each matched node. History is followed through
+// whichever parent has the same value, like git blame follows lines.
+func BlameFile(head *object.Commit, file *object.File, exp *yqlib.ExpressionNode, inputFormat string, variables map[string]string) ([]BlameLine, error) {
+	rc, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+
+	result, err := EvaluateExpression(rc, exp, file.Name, inputFormat, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	history := fileValues{
+		fileName:    file.Name,
+		inputFormat: inputFormat,
+		documents:   make(map[plumbing.Hash][]*yqlib.CandidateNode),
+	}
+
+	var lines []BlameLine
+	for el := result.Front(); el != nil; el = el.Next() {
+		node := el.Value.(*yqlib.CandidateNode)
+		path := node.GetPath()
+		document := int(node.GetDocument())
+
+		commit, blameErr := history.lastChange(head, document, path, nodeFingerprint(node))
+		if blameErr != nil {
+			return nil, blameErr
+		}
+
+		value, encodeErr := compactJSON(node)
+		if encodeErr != nil {
+			return nil, encodeErr
+		}
+
+		lines = append(lines, BlameLine{
+			Document: document,
+			Path:     path,
+			Value:    value,
+			Commit:   commit,
+		})
+	}
+	return lines, nil
+}
+
+// fileValues looks up values in a file across commits, decoding each
+// distinct blob once.
+type fileValues struct {
+	fileName    string
+	inputFormat string
+	documents   map[plumbing.Hash][]*yqlib.CandidateNode
+}
+
+func (values fileValues) lastChange(commit *object.Commit, document int, path []any, fingerprint string) (*object.Commit, error) {
+	for {
+		var next *object.Commit
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			parentFingerprint, found, err := values.fingerprintAt(parent, document, path)
+			if err != nil {
+				return err
+			}
+			if found && parentFingerprint == fingerprint {
+				next = parent
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return commit, nil
+		}
+		commit = next
+	}
+}
+
+func (values fileValues) fingerprintAt(commit *object.Commit, document int, path []any) (string, bool, error) {
+	file, err := commit.File(values.fileName)
+	if errors.Is(err, object.ErrFileNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	documents, ok := values.documents[file.Hash]
+	if !ok {
+		rc, readerErr := file.Reader()
+		if readerErr != nil {
+			return "", false, readerErr
+		}
+		documents, err = decodeDocuments(rc, file.Name, values.inputFormat)
+		_ = rc.Close()
+		if err != nil {
+			// a version of the file that can not be parsed does not have the value
+			documents = nil
+		}
+		values.documents[file.Hash] = documents
+	}
+	if document >= len(documents) {
+		return "", false, nil
+	}
+
+	node, found := nodeAtPath(documents[document], path)
+	if !found {
+		return "", false, nil
+	}
+	return nodeFingerprint(node), true, nil
+}
+
+func nodeAtPath(node *yqlib.CandidateNode, path []any) (*yqlib.CandidateNode, bool) {
+	for _, element := range path {
+		if node.Kind == yqlib.AliasNode && node.Alias != nil {
+			node = node.Alias
+		}
+		switch node.Kind {
+		case yqlib.MappingNode:
+			key := fmt.Sprint(element)
+			var child *yqlib.CandidateNode
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					child = node.Content[i+1]
+					break
+				}
+			}
+			if child == nil {
+				return nil, false
+			}
+			node = child
+		case yqlib.SequenceNode:
+			index, ok := element.(int)
+			if !ok || index < 0 || index >= len(node.Content) {
+				return nil, false
+			}
+			node = node.Content[index]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// nodeFingerprint returns a representation of the node's value that ignores
+// comments, quoting and layout, and the order of mapping keys.
+func nodeFingerprint(node *yqlib.CandidateNode) string {
+	if node.Kind == yqlib.AliasNode && node.Alias != nil {
+		return nodeFingerprint(node.Alias)
+	}
+	switch node.Kind {
+	case yqlib.MappingNode:
+		entries := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			entries = append(entries, fmt.Sprintf("%q:%s", node.Content[i].Value, nodeFingerprint(node.Content[i+1])))
+		}
+		slices.Sort(entries)
+		return "{" + strings.Join(entries, ",") + "}"
+	case yqlib.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, child := range node.Content {
+			items = append(items, nodeFingerprint(child))
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	default:
+		return node.Tag + " " + node.Value
+	}
+}
+
+var simpleKeyExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// yqPath formats a node path as a yq path expression like ".image.tags[0]".
+func yqPath(path []any) string {
+	if len(path) == 0 {
+		return "."
+	}
+	var sb strings.Builder
+	for _, element := range path {
+		switch e := element.(type) {
+		case int:
+			_, _ = fmt.Fprintf(&sb, "[%d]", e)
+		default:
+			key := fmt.Sprint(e)
+			if simpleKeyExp.MatchString(key) {
+				sb.WriteString("." + key)
+			} else {
+				_, _ = fmt.Fprintf(&sb, ".[%q]", key)
+			}
+		}
+	}
+	return sb.String()
+}
+
+func compactJSON(node *yqlib.CandidateNode) (string, error) {
+	var buf bytes.Buffer
+	encoder := yqlib.NewJSONEncoder(yqlib.JsonPreferences{Indent: 0})
+	if err := encoder.Encode(&buf, node); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
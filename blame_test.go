@@ -0,0 +1,67 @@
+package qyt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlame(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	var hashes []string
+	for i, contents := range []string{
+		"image:\n  name: app\n  tag: \"1.0\"\nreplicas: 1\n",
+		"image:\n  name: app\n  tag: \"1.1\"\nreplicas: 1\n",
+		"replicas: 2\nimage:\n  name: app\n  tag: \"1.1\"\n",
+		"# reformatted\nreplicas: 2\nimage: {tag: '1.1', name: app}\n",
+	} {
+		createFile(t, wt.Filesystem, "values.yaml", contents)
+		_, addErr := wt.Add("values.yaml")
+		if !assert.NoError(t, addErr) {
+			return
+		}
+		signature := someSignature()
+		signature.When = signature.When.Add(time.Duration(i) * time.Hour)
+		hash, commitErr := wt.Commit("change values", &git.CommitOptions{Author: &signature, Committer: &signature})
+		if !assert.NoError(t, commitErr) {
+			return
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	var out bytes.Buffer
+	blameErr := Blame(&out, repo, ".image.tag, .image.name, .replicas, .image", RefFilter{Branches: "master"}, FileFilter{Pattern: `values\.yaml`}, false)
+	if !assert.NoError(t, blameErr) {
+		return
+	}
+
+	assert.Equal(t, strings.Join([]string{
+		hashes[1] + " (christopher <christopher@exmaple.com> 2021-06-03T01:29:38Z) master values.yaml .image.tag: \"1.1\"",
+		hashes[0] + " (christopher <christopher@exmaple.com> 2021-06-03T00:29:38Z) master values.yaml .image.name: \"app\"",
+		hashes[2] + " (christopher <christopher@exmaple.com> 2021-06-03T02:29:38Z) master values.yaml .replicas: 2",
+		hashes[1] + " (christopher <christopher@exmaple.com> 2021-06-03T01:29:38Z) master values.yaml .image: {\"tag\":\"1.1\",\"name\":\"app\"}",
+	}, "\n")+"\n", out.String())
+}
+
+func TestYQPath(t *testing.T) {
+	assert.Equal(t, ".", yqPath(nil))
+	assert.Equal(t, ".spec.containers[0].image", yqPath([]any{"spec", "containers", 0, "image"}))
+	assert.Equal(t, `.metadata.labels.["app.kubernetes.io/name"]`, yqPath([]any{"metadata", "labels", "app.kubernetes.io/name"}))
+}
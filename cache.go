@@ -0,0 +1,271 @@
+package qyt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// ResultCache memoizes the nodes an expression matches in a file so a blob
+// that is the same on many refs is only decoded and evaluated once. Entries
+// are keyed by the expression, the file format, the blob hash, and the scope
+// variables the expression references. It is safe for concurrent use.
+//
+// Cached nodes are shared by every Result read from the same blob and must
+// not be modified.
+type ResultCache struct {
+	dir string
+
+	mu      sync.Mutex
+	entries map[string][][]*yqlib.CandidateNode
+}
+
+// NewResultCache returns a cache that only keeps entries in memory.
+func NewResultCache() *ResultCache {
+	return &ResultCache{entries: make(map[string][][]*yqlib.CandidateNode)}
+}
+
+// OpenResultCache returns a cache that also persists entries as files in dir
+// so they can be reused by later queries.
+func OpenResultCache(dir string) (*ResultCache, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("could not create cache directory: %w", err)
+	}
+	cache := NewResultCache()
+	cache.dir = dir
+	return cache, nil
+}
+
+// RepositoryResultCache returns a cache persisted in the qyt-cache directory
+// of the repository's git directory. Repositories that are not stored on disk
+// get an in-memory cache.
+func RepositoryResultCache(repo *git.Repository) (*ResultCache, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return NewResultCache(), nil
+	}
+	return OpenResultCache(filepath.Join(storage.Filesystem().Root(), "qyt-cache"))
+}
+
+func (cache *ResultCache) get(key string) ([][]*yqlib.CandidateNode, bool) {
+	cache.mu.Lock()
+	documents, ok := cache.entries[key]
+	cache.mu.Unlock()
+	if ok || cache.dir == "" {
+		return documents, ok
+	}
+
+	documents, err := readCacheEntry(cache.entryPath(key))
+	if err != nil {
+		// a missing or unreadable entry is evaluated again
+		return nil, false
+	}
+
+	cache.mu.Lock()
+	cache.entries[key] = documents
+	cache.mu.Unlock()
+	return documents, true
+}
+
+func (cache *ResultCache) put(key string, documents [][]*yqlib.CandidateNode) {
+	cache.mu.Lock()
+	cache.entries[key] = documents
+	cache.mu.Unlock()
+
+	if cache.dir != "" {
+		// the entry is still cached in memory when it can not be persisted
+		_ = writeCacheEntry(cache.entryPath(key), documents)
+	}
+}
+
+func (cache *ResultCache) entryPath(key string) string {
+	return filepath.Join(cache.dir, key[:2], key[2:]+".json")
+}
+
+// expressionDependencies lists what, besides the blob, the result of an
+// expression depends on.
+type expressionDependencies struct {
+	variables []string
+	filename  bool
+
+	// uncacheable is set when the result depends on the environment, other
+	// files, the time, variables named in an evaluated string, or is random.
+	uncacheable bool
+}
+
+func dependenciesOf(exp *yqlib.ExpressionNode) expressionDependencies {
+	var deps expressionDependencies
+	var walk func(exp *yqlib.ExpressionNode)
+	walk = func(exp *yqlib.ExpressionNode) {
+		if exp == nil {
+			return
+		}
+		if exp.Operation != nil && exp.Operation.OperationType != nil {
+			switch exp.Operation.OperationType.Type {
+			case "GET_VARIABLE":
+				if !slices.Contains(deps.variables, exp.Operation.StringValue) {
+					deps.variables = append(deps.variables, exp.Operation.StringValue)
+				}
+			case "GET_FILENAME":
+				deps.filename = true
+			case "ENV", "ENVSUBST", "EVAL", "LOAD", "LOAD_STRING", "NOW", "SHUFFLE":
+				deps.uncacheable = true
+			}
+		}
+		walk(exp.LHS)
+		walk(exp.RHS)
+	}
+	walk(exp)
+	slices.Sort(deps.variables)
+	return deps
+}
+
+// key returns the cache key for evaluating expString on file or an empty
+// string when the result should not be cached.
+func (deps expressionDependencies) key(expString, inputFormat string, file *object.File, scope map[string]string) string {
+	if deps.uncacheable {
+		return ""
+	}
+	format, err := FileFormat(file.Name, inputFormat)
+	if err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, part := range []string{expString, format.FormalName, file.Hash.String()} {
+		_, _ = io.WriteString(h, part)
+		_, _ = h.Write([]byte{0})
+	}
+	if deps.filename {
+		_, _ = io.WriteString(h, file.Name)
+	}
+	for _, name := range deps.variables {
+		_, _ = fmt.Fprintf(h, "\x00%s=%s", name, scope[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cachedNode is a matched node as it is persisted on disk. The path is kept
+// so the node can be placed where it was found.
+type cachedNode struct {
+	Path           []any  `json:"path"`
+	IsMapKey       bool   `json:"isMapKey,omitempty"`
+	LeadingContent string `json:"leadingContent,omitempty"`
+	YAML           string `json:"yaml"`
+}
+
+func writeCacheEntry(path string, documents [][]*yqlib.CandidateNode) error {
+	encoder := yqlib.NewYamlEncoder(yqlib.YamlPreferences{Indent: 2})
+
+	entry := make([][]cachedNode, 0, len(documents))
+	for _, nodes := range documents {
+		cached := make([]cachedNode, 0, len(nodes))
+		for _, node := range nodes {
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, node); err != nil {
+				return err
+			}
+			cached = append(cached, cachedNode{
+				Path:           node.GetPath(),
+				IsMapKey:       node.IsMapKey,
+				LeadingContent: node.LeadingContent,
+				YAML:           buf.String(),
+			})
+		}
+		entry = append(entry, cached)
+	}
+
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	_, writeErr := tmp.Write(buf)
+	closeErr := tmp.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readCacheEntry(path string) ([][]*yqlib.CandidateNode, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry [][]cachedNode
+	if err := json.Unmarshal(buf, &entry); err != nil {
+		return nil, err
+	}
+
+	prefs := yqlib.NewDefaultYamlPreferences()
+	prefs.LeadingContentPreProcessing = false
+
+	documents := make([][]*yqlib.CandidateNode, 0, len(entry))
+	for documentIndex, cached := range entry {
+		nodes := make([]*yqlib.CandidateNode, 0, len(cached))
+		for _, c := range cached {
+			decoder := yqlib.NewYamlDecoder(prefs)
+			if err := decoder.Init(bytes.NewReader([]byte(c.YAML))); err != nil {
+				return nil, err
+			}
+			node, err := decoder.Decode()
+			if err != nil {
+				return nil, err
+			}
+			node.IsMapKey = c.IsMapKey
+			node.LeadingContent = c.LeadingContent
+			placeNode(node, uint(documentIndex), c.Path)
+			nodes = append(nodes, node)
+		}
+		documents = append(documents, nodes)
+	}
+	return documents, nil
+}
+
+// placeNode gives node parents so that its GetPath and GetDocument methods
+// return path and document.
+func placeNode(node *yqlib.CandidateNode, document uint, path []any) {
+	if len(path) == 0 {
+		node.SetDocument(document)
+		return
+	}
+
+	parent := &yqlib.CandidateNode{Kind: yqlib.MappingNode}
+	parent.SetDocument(document)
+	for i, element := range path {
+		key := &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!str", Value: fmt.Sprint(element)}
+		// JSON numbers decode as float64
+		if index, ok := element.(float64); ok {
+			key.Tag, key.Value = "!!int", strconv.Itoa(int(index))
+		}
+
+		child := node
+		if i < len(path)-1 {
+			child = &yqlib.CandidateNode{Kind: yqlib.MappingNode}
+		}
+		child.Parent, child.Key = parent, key
+		parent = child
+	}
+}
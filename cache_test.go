@@ -0,0 +1,140 @@
+package qyt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependenciesOf(t *testing.T) {
+	for _, tt := range []struct {
+		exp      string
+		expected expressionDependencies
+	}{
+		{exp: `.name`},
+		{exp: `.name + $branch + $filename + $branch`, expected: expressionDependencies{variables: []string{"branch", "filename"}}},
+		{exp: `filename`, expected: expressionDependencies{filename: true}},
+		{exp: `.home = env(HOME)`, expected: expressionDependencies{uncacheable: true}},
+		{exp: `.items | shuffle`, expected: expressionDependencies{uncacheable: true}},
+		{exp: `eval("$branch")`, expected: expressionDependencies{uncacheable: true}},
+	} {
+		t.Run(tt.exp, func(t *testing.T) {
+			exp, err := yqlib.ExpressionParser.ParseExpression(tt.exp)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.expected, dependenciesOf(exp))
+		})
+	}
+}
+
+func TestQuery_cache(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	createSomeFilesWithNameKey(t, repo, "", "foo")
+	createSomeFilesWithNameKey(t, repo, "b", "bar")
+	createSomeFilesWithNameKey(t, repo, "c", "baz")
+
+	t.Run("blobs are evaluated once", func(t *testing.T) {
+		cache := NewResultCache()
+		var out bytes.Buffer
+		if !assert.NoError(t, Query(&out, repo, `.name`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, cache, 1, false, false)) {
+			return
+		}
+		assert.Equal(t, "about bar\nabout foo\nabout bar\nabout baz\nabout foo\nabout foo\n", out.String())
+		assert.Len(t, cache.entries, 3)
+	})
+
+	t.Run("referenced variables are part of the key", func(t *testing.T) {
+		cache := NewResultCache()
+		var out bytes.Buffer
+		if !assert.NoError(t, Query(&out, repo, `$branch`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `foo\.yml`}, cache, 1, false, false)) {
+			return
+		}
+		assert.Equal(t, "b\nc\nmaster\n", out.String())
+		assert.Len(t, cache.entries, 3)
+	})
+
+	t.Run("variables named in eval", func(t *testing.T) {
+		cache := NewResultCache()
+		var out bytes.Buffer
+		if !assert.NoError(t, Query(&out, repo, `eval("$" + "branch")`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `foo\.yml`}, cache, 1, false, false)) {
+			return
+		}
+		assert.Equal(t, "b\nc\nmaster\n", out.String())
+		assert.Empty(t, cache.entries)
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		dir := t.TempDir()
+		const exp = `{"name": .name, "branch": $branch} | .name`
+
+		cache, openErr := OpenResultCache(dir)
+		if !assert.NoError(t, openErr) {
+			return
+		}
+		var first bytes.Buffer
+		if !assert.NoError(t, Query(&first, repo, exp, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, cache, 1, false, true)) {
+			return
+		}
+
+		entries, globErr := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+		assert.NoError(t, globErr)
+		assert.Len(t, entries, 6)
+
+		cache, openErr = OpenResultCache(dir)
+		if !assert.NoError(t, openErr) {
+			return
+		}
+		var second bytes.Buffer
+		if !assert.NoError(t, Query(&second, repo, exp, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, cache, 1, false, true)) {
+			return
+		}
+		assert.Equal(t, first.String(), second.String())
+		assert.Len(t, cache.entries, 6)
+	})
+}
+
+func TestResultCache_persisted_paths(t *testing.T) {
+	dir := t.TempDir()
+	exp, err := yqlib.ExpressionParser.ParseExpression(`.spec.ports[1]`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	documents, err := evaluateFile([]byte("kind: a\n---\nspec:\n  ports: [80, 443] # https\n"), exp, "values.yml", "", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, writeCacheEntry(filepath.Join(dir, "entry.json"), documents)) {
+		return
+	}
+	got, err := readCacheEntry(filepath.Join(dir, "entry.json"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.Len(t, got, 2) || !assert.Len(t, got[1], 1) {
+		return
+	}
+	node := got[1][0]
+	assert.Equal(t, []any{"spec", "ports", 1}, node.GetPath())
+	assert.Equal(t, uint(1), node.GetDocument())
+	assert.Equal(t, "443", node.Value)
+	assert.Equal(t, "!!int", node.Tag)
+
+	_, statErr := os.Stat(filepath.Join(dir, "entry.json"))
+	assert.NoError(t, statErr)
+}
@@ -0,0 +1,129 @@
+package qyt
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// CheckReport lists the documents where an assertion did not hold.
+type CheckReport struct {
+	// Checked is the number of documents the assertion was evaluated on.
+	Checked  int            `json:"checked"`
+	Failures []CheckFailure `json:"failures"`
+	Errors   []CheckError   `json:"errors"`
+}
+
+// CheckFailure is a document where the assertion did not evaluate to true.
+// Values holds the result nodes encoded as compact JSON.
+type CheckFailure struct {
+	Ref      string   `json:"ref"`
+	Commit   string   `json:"commit"`
+	File     string   `json:"file"`
+	Document int      `json:"document"`
+	Values   []string `json:"values"`
+}
+
+// CheckError is a file the assertion could not be evaluated on.
+type CheckError struct {
+	Ref   string `json:"ref"`
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// Passed reports whether the assertion held for every document. A check that
+// did not match any documents did not pass, so a mistyped ref or file filter
+// is not mistaken for a passing check.
+func (report CheckReport) Passed() bool {
+	return report.Checked > 0 && len(report.Failures) == 0 && len(report.Errors) == 0
+}
+
+// WriteText writes a line for each failure and error followed by a summary.
+func (report CheckReport) WriteText(w io.Writer) error {
+	for _, failure := range report.Failures {
+		location := failure.File
+		if failure.Document > 0 {
+			location += fmt.Sprintf("#%d", failure.Document)
+		}
+		values := "no result"
+		if len(failure.Values) > 0 {
+			values = strings.Join(failure.Values, ", ")
+		}
+		if _, err := fmt.Fprintf(w, "fail %s %s: %s\n", failure.Ref, location, values); err != nil {
+			return err
+		}
+	}
+	for _, checkErr := range report.Errors {
+		if _, err := fmt.Fprintf(w, "error %s %s: %s\n", checkErr.Ref, checkErr.File, checkErr.Error); err != nil {
+			return err
+		}
+	}
+	if report.Checked == 0 && len(report.Errors) == 0 {
+		if _, err := fmt.Fprintln(w, "error: no documents matched the ref and file filters"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# checked %d documents: %d failed, %d errors\n", report.Checked, len(report.Failures), len(report.Errors))
+	return err
+}
+
+// Check evaluates the assertion on every document of the matched files on the
+// matched refs. A document passes when the expression returns at least one
+// node and every node is true. Files that can not be evaluated are reported
+// as errors; the returned error is only set when the expression, refs, or
+// files can not be resolved at all.
+func Check(repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, cache *ResultCache, jobs int) (CheckReport, error) {
+	report := CheckReport{
+		Failures: []CheckFailure{},
+		Errors:   []CheckError{},
+	}
+
+	for result, err := range Results(repo, yqExp, refFilter, fileFilter, cache, jobs) {
+		if err != nil {
+			if result.File == "" && result.Ref.Name() == "" {
+				return report, err
+			}
+			report.Errors = append(report.Errors, CheckError{
+				Ref:   result.Ref.Name().Short(),
+				File:  result.File,
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		report.Checked++
+		if assertionHolds(result.Nodes) {
+			continue
+		}
+
+		failure := CheckFailure{
+			Ref:      result.Ref.Name().Short(),
+			Commit:   result.Commit.String(),
+			File:     result.File,
+			Document: result.Document,
+			Values:   []string{},
+		}
+		for _, node := range result.Nodes {
+			value, encodeErr := compactJSON(node)
+			if encodeErr != nil {
+				return report, encodeErr
+			}
+			failure.Values = append(failure.Values, value)
+		}
+		report.Failures = append(report.Failures, failure)
+	}
+
+	return report, nil
+}
+
+func assertionHolds(nodes []*yqlib.CandidateNode) bool {
+	for _, node := range nodes {
+		if node.Tag != "!!bool" || node.Value != "true" {
+			return false
+		}
+	}
+	return len(nodes) > 0
+}
@@ -0,0 +1,109 @@
+package qyt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	sig := someSignature()
+	commitFiles := func(branch string, files map[string]string) {
+		t.Helper()
+		if branch != "" {
+			assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Create: true, Branch: plumbing.NewBranchReferenceName(branch)}))
+		}
+		for name, contents := range files {
+			createFile(t, wt.Filesystem, name, contents)
+			_, addErr := wt.Add(name)
+			assert.NoError(t, addErr)
+		}
+		_, commitErr := wt.Commit("update "+branch, &git.CommitOptions{Author: &sig, Committer: &sig})
+		assert.NoError(t, commitErr)
+	}
+
+	commitFiles("", map[string]string{"deploy.yml": "spec:\n  replicas: 3\n"})
+	commitFiles("low", map[string]string{"deploy.yml": "spec:\n  replicas: 1\n---\nspec:\n  replicas: 2\n"})
+	commitFiles("broken", map[string]string{"deploy.yml": "spec: [\n"})
+
+	t.Run("passes", func(t *testing.T) {
+		report, err := Check(repo, `.spec.replicas >= 2`, RefFilter{Branches: "^master$"}, FileFilter{Pattern: `deploy\.yml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, report.Passed())
+		assert.Equal(t, 1, report.Checked)
+	})
+
+	t.Run("fails", func(t *testing.T) {
+		report, err := Check(repo, `.spec.replicas >= 2`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `deploy\.yml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, report.Passed())
+		assert.Equal(t, 3, report.Checked)
+		if assert.Len(t, report.Failures, 1) {
+			assert.Equal(t, CheckFailure{
+				Ref:      "low",
+				Commit:   report.Failures[0].Commit,
+				File:     "deploy.yml",
+				Document: 0,
+				Values:   []string{"false"},
+			}, report.Failures[0])
+		}
+		if assert.Len(t, report.Errors, 1) {
+			assert.Equal(t, "broken", report.Errors[0].Ref)
+			assert.Equal(t, "deploy.yml", report.Errors[0].File)
+		}
+
+		var out bytes.Buffer
+		assert.NoError(t, report.WriteText(&out))
+		assert.Contains(t, out.String(), "fail low deploy.yml: false\n")
+		assert.Contains(t, out.String(), "error broken deploy.yml: ")
+		assert.Contains(t, out.String(), "# checked 3 documents: 1 failed, 1 errors\n")
+	})
+
+	t.Run("no result", func(t *testing.T) {
+		report, err := Check(repo, `.spec.missing[]`, RefFilter{Branches: "^master$"}, FileFilter{Pattern: `deploy\.yml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.Failures, 1) {
+			assert.Empty(t, report.Failures[0].Values)
+		}
+	})
+
+	t.Run("nothing matched", func(t *testing.T) {
+		report, err := Check(repo, `.spec.replicas >= 2`, RefFilter{Branches: "^master$"}, FileFilter{Pattern: `deploy\.yaml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 0, report.Checked)
+		assert.False(t, report.Passed())
+
+		var out bytes.Buffer
+		assert.NoError(t, report.WriteText(&out))
+		assert.Equal(t, "error: no documents matched the ref and file filters\n# checked 0 documents: 0 failed, 0 errors\n", out.String())
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		_, err := Check(repo, `.spec[`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `deploy\.yml`}, nil, 1)
+		assert.Error(t, err)
+	})
+}
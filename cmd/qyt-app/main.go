@@ -19,9 +19,7 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/mikefarah/yq/v4/pkg/yqlib"
 	"gopkg.in/op/go-logging.v1"
 
@@ -57,6 +55,7 @@ type qytApp struct {
 	config    qyt.Configuration
 	repo      *git.Repository
 	expParser yqlib.ExpressionParserInterface
+	cache     *qyt.ResultCache
 
 	window fyne.Window
 	view   *container.Split
@@ -98,6 +97,7 @@ func initApp(createApp func() fyne.App, config qyt.Configuration, repo *git.Repo
 
 	qa := &qytApp{
 		repo:                repo,
+		cache:               qyt.NewResultCache(),
 		config:              config,
 		expParser:           yqlib.ExpressionParser,
 		window:              mainWindow,
@@ -264,7 +264,7 @@ func (qa *qytApp) runQuery(repo *git.Repository) {
 	b := qa.branchEntry.Text
 	f := qa.pathEntry.Text
 	q := qa.queryEntry.Text
-	branchFilter, fileFilter, queryExp, err := qa.parseFields(b, f, q)
+	branchFilter, fileFilter, _, err := qa.parseFields(b, f, q)
 	if err != nil {
 		qa.displayError(err)
 		return
@@ -287,54 +287,87 @@ func (qa *qytApp) runQuery(repo *git.Repository) {
 
 	qa.clearBranchesAndError()
 
-	references, err := qyt.MatchingBranches(branchFilter.String(), qa.repo, false)
-	if err != nil {
-		qa.displayError(err)
-		return
-	}
-
-	buf := new(bytes.Buffer)
-	count := 0
-	for _, ref := range references {
-		fileTabs := qa.createNewBranchTab(ref)
+	refFilter := qa.config.Refs()
+	refFilter.Branches = branchFilter.String()
 
-		var obj object.Object
-		obj, err := repo.Object(plumbing.CommitObject, ref.Hash())
+	var (
+		buf      = new(bytes.Buffer)
+		encoder  *qyt.ResultEncoder
+		fileTabs *container.AppTabs
+		current  qyt.Result
+		count    = 0
+	)
+	showFile := func() {
+		if current.File != "" {
+			qa.createFilesView(fileTabs, current.File, buf.String())
+		}
+	}
+	for result, err := range qyt.Results(qa.repo, q, refFilter, qyt.FileFilter{Pattern: fileFilter.String(), Format: qa.config.InputFormat}, qa.cache, qa.config.Jobs) {
 		if err != nil {
 			qa.displayError(err)
-			return
+			continue
 		}
-		err = qyt.HandleMatchingFiles(obj, fileFilter, func(file *object.File) (err error) {
-			count++
-			rc, err := file.Reader()
-			if err != nil {
-				return err
-			}
-			defer func() {
-				err = rc.Close()
-			}()
-			input, err := io.ReadAll(rc)
-			if err != nil {
-				return err
+		if result.Ref.Name() != current.Ref.Name() || result.File != current.File {
+			showFile()
+			if result.Ref.Name() != current.Ref.Name() {
+				fileTabs = qa.createNewBranchTab(result.Ref)
 			}
 			buf.Reset()
-			err = qyt.ApplyExpression(buf, bytes.NewReader(input), queryExp, file.Name, qyt.NewScope(ref, file), false)
-			if err != nil {
-				return err
-			}
-
-			qa.createFilesView(fileTabs, file.Name, buf.String())
-			return nil
-		})
-		if err != nil {
+			encoder = qyt.NewResultEncoder(buf, false)
+			count++
+		}
+		current = result
+		if err := encoder.Encode(result); err != nil {
 			qa.displayError(err)
-			continue
 		}
 	}
-	if count == 0 && err == nil {
+	showFile()
+
+	if count == 0 && qa.errMessage.Text == "" {
 		qa.displayError(fmt.Errorf("no matching files"))
 		return
 	}
+
+	qa.createDiffViews(q, qyt.FileFilter{Pattern: fileFilter.String(), Format: qa.config.InputFormat})
+}
+
+// createDiffViews adds a diff view to the files of every branch tab after the
+// first. The diff compares the query result with the one on the first branch.
+func (qa *qytApp) createDiffViews(q string, fileFilter qyt.FileFilter) {
+	qa.Lock()
+	defer qa.Unlock()
+
+	if len(qa.branchTabs.Items) < 2 {
+		return
+	}
+	base := qa.branchTabs.Items[0].Text
+	for _, branchTab := range qa.branchTabs.Items[1:] {
+		report, err := qyt.DiffRefs(qa.repo, q, base, branchTab.Text, fileFilter, qa.cache, qa.config.Jobs)
+		if err != nil {
+			qa.errMessage.SetText(err.Error())
+			qa.errMessage.Show()
+			return
+		}
+		fileTabs := branchTab.Content.(*container.AppTabs)
+		for _, fileTab := range fileTabs.Items {
+			diff := fmt.Sprintf("no changes from %s\n", base)
+			for _, fileDiff := range report.Files {
+				if fileDiff.File != fileTab.Text {
+					continue
+				}
+				var buf bytes.Buffer
+				if err := fileDiff.WriteText(&buf); err != nil {
+					diff = err.Error()
+				} else {
+					diff = buf.String()
+				}
+			}
+			contents := widget.NewRichTextWithText(diff)
+			contents.Wrapping = fyne.TextWrapOff
+			fileViews := fileTab.Content.(*container.AppTabs)
+			fileViews.Append(container.NewTabItem(FileViewNameDiff, container.NewScroll(contents)))
+		}
+	}
 }
 
 func (qa *qytApp) createNewBranchTab(ref plumbing.Reference) *container.AppTabs {
@@ -460,7 +493,12 @@ func (qa *qytApp) selectAllFileViewsWithName(s string) {
 }
 
 func (qa *qytApp) commit(commitTemplate, branchPrefix string, existingBranches bool) {
-	sig, err := getSignature(qa.repo, time.Now())
+	author, committer, err := qyt.Identity(qa.repo, time.Now())
+	if err != nil {
+		qa.displayError(err)
+		return
+	}
+	signer, err := qyt.CommitSigner(qa.repo, false)
 	if err != nil {
 		qa.displayError(err)
 		return
@@ -468,13 +506,15 @@ func (qa *qytApp) commit(commitTemplate, branchPrefix string, existingBranches b
 	if existingBranches {
 		branchPrefix = ""
 	}
-	err = qyt.Apply(qa.repo,
+	refFilter := qa.config.Refs()
+	refFilter.Branches = qa.branchEntry.Text
+	_, err = qyt.Apply(qa.repo,
 		qa.queryEntry.Text,
-		qa.branchEntry.Text,
-		qa.pathEntry.Text,
+		refFilter,
+		qyt.FileFilter{Pattern: qa.pathEntry.Text, Format: qa.config.InputFormat},
 		commitTemplate,
 		branchPrefix,
-		sig, false, existingBranches,
+		author, committer, signer, qa.config.Jobs, false, existingBranches,
 	)
 	if err != nil {
 		qa.displayError(err)
@@ -482,24 +522,6 @@ func (qa *qytApp) commit(commitTemplate, branchPrefix string, existingBranches b
 	}
 }
 
-func getSignature(repo *git.Repository, now time.Time) (object.Signature, error) {
-	conf, err := repo.ConfigScoped(config.SystemScope)
-	if err != nil {
-		return object.Signature{}, fmt.Errorf("could not get git config: %w", err)
-	}
-	if conf.User.Name == "" {
-		return object.Signature{}, fmt.Errorf("git user name not set in config")
-	}
-	if conf.User.Email == "" {
-		return object.Signature{}, fmt.Errorf("git user email not set in config")
-	}
-	return object.Signature{
-		Name:  conf.User.Name,
-		Email: conf.User.Email,
-		When:  now,
-	}, nil
-}
-
 type qytTheme struct {
 	fyne.Theme
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	_ "embed"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -9,8 +10,6 @@ import (
 	"time"
 
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"gopkg.in/op/go-logging.v1"
 
 	"github.com/crhntr/qyt"
@@ -29,58 +28,221 @@ func main() {
 	args := flag.Args()
 	if len(args) <= 1 {
 		fmt.Println("Usage: qyt <command> [<args>]")
-		os.Exit(1)
+		os.Exit(setupErrorExitCode(flag.Arg(0)))
 	}
 
 	qytConfig, usage, err := qyt.LoadConfiguration(args[1:])
 	if err != nil {
 		usage()
-		os.Exit(1)
+		os.Exit(setupErrorExitCode(flag.Arg(0)))
 	}
 	repo, err := git.PlainOpen(qytConfig.GitRepositoryPath)
 	if err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "failed to open repository", err)
 		usage()
-		os.Exit(1)
+		os.Exit(setupErrorExitCode(flag.Arg(0)))
 	}
 
 	switch flag.Arg(0) {
 	case "query":
-		err = qyt.Query(os.Stdout, repo, qytConfig.Query, qytConfig.BranchFilter, qytConfig.FileNameFilter, false, false)
+		cache, cacheErr := resultCache(repo, qytConfig)
+		if cacheErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "query error: %s\n", cacheErr.Error())
+			os.Exit(1)
+		}
+		if qytConfig.Reduce != "" {
+			err = qyt.Aggregate(os.Stdout, repo, qytConfig.Query, qytConfig.Reduce, qytConfig.Refs(), qytConfig.Files(), cache, qytConfig.Jobs, qytConfig.JSON)
+		} else {
+			err = qyt.Query(os.Stdout, repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), cache, qytConfig.Jobs, false, qytConfig.JSON)
+		}
 		if err != nil {
 			_, _ = fmt.Fprintf(os.Stderr, "query error: %s\n", err.Error())
 			os.Exit(1)
 		}
+	case "check":
+		cache, cacheErr := resultCache(repo, qytConfig)
+		if cacheErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "check error: %s\n", cacheErr.Error())
+			os.Exit(exitCheckError)
+		}
+		report, checkErr := qyt.Check(repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), cache, qytConfig.Jobs)
+		if checkErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "check error: %s\n", checkErr.Error())
+			os.Exit(exitCheckError)
+		}
+		if qytConfig.JSON {
+			err = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			err = report.WriteText(os.Stdout)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "check error: %s\n", err.Error())
+			os.Exit(exitCheckError)
+		}
+		switch {
+		case len(report.Errors) > 0, report.Checked == 0:
+			os.Exit(exitCheckError)
+		case len(report.Failures) > 0:
+			os.Exit(exitCheckFailed)
+		}
+	case "drift":
+		cache, cacheErr := resultCache(repo, qytConfig)
+		if cacheErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "drift error: %s\n", cacheErr.Error())
+			os.Exit(1)
+		}
+		report, driftErr := qyt.Drift(repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), cache, qytConfig.Jobs)
+		if driftErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "drift error: %s\n", driftErr.Error())
+			os.Exit(1)
+		}
+		if qytConfig.JSON {
+			err = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			err = report.WriteTable(os.Stdout)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "drift error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "diff":
+		if len(qytConfig.Args) != 2 {
+			_, _ = fmt.Fprintln(os.Stderr, "Usage: qyt diff <base> <head> [-q <expression>]")
+			os.Exit(1)
+		}
+		cache, cacheErr := resultCache(repo, qytConfig)
+		if cacheErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "diff error: %s\n", cacheErr.Error())
+			os.Exit(1)
+		}
+		report, diffErr := qyt.DiffRefs(repo, qytConfig.DiffQuery(), qytConfig.Args[0], qytConfig.Args[1], qytConfig.Files(), cache, qytConfig.Jobs)
+		if diffErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "diff error: %s\n", diffErr.Error())
+			os.Exit(1)
+		}
+		if qytConfig.JSON {
+			err = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			err = report.WriteText(os.Stdout)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "diff error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "log":
+		err = qyt.Log(os.Stdout, repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), false, qytConfig.JSON, qytConfig.OnlyChanges)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "log error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "blame":
+		err = qyt.Blame(os.Stdout, repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), false)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "blame error: %s\n", err.Error())
+			os.Exit(1)
+		}
+	case "bisect":
+		err = qyt.Bisect(os.Stdout, repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), false)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "bisect error: %s\n", err.Error())
+			os.Exit(1)
+		}
 	case "apply":
-		author, getSignatureErr := getSignature(repo, time.Now())
-		if getSignatureErr != nil {
-			_, _ = fmt.Fprintln(os.Stderr, "failed to open repository", getSignatureErr)
+		if qytConfig.Worktree {
+			changed, applyErr := qyt.ApplyWorktree(repo, qytConfig.Query, qytConfig.Files(), qytConfig.Stage, qytConfig.Force, false)
+			if applyErr != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", applyErr.Error())
+				for _, file := range changed {
+					_, _ = fmt.Fprintf(os.Stderr, "file %s was already written\n", file.File)
+				}
+				os.Exit(1)
+			}
+			if qytConfig.JSON {
+				err = json.NewEncoder(os.Stdout).Encode(changed)
+			} else {
+				for _, file := range changed {
+					if _, err = fmt.Fprintf(os.Stdout, "%s %s\n", file.Status, file.File); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+		author, committer, identityErr := qyt.Identity(repo, time.Now())
+		if identityErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", identityErr.Error())
 			os.Exit(1)
 		}
 
-		err = qyt.Apply(repo, qytConfig.Query, qytConfig.BranchFilter, qytConfig.FileNameFilter, qytConfig.CommitTemplate, qytConfig.NewBranchPrefix, author, false, allowOverridingExistingBranches)
+		if qytConfig.DryRun {
+			err = qyt.ApplyDryRun(os.Stdout, repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), qytConfig.CommitTemplate, qytConfig.NewBranchPrefix, author, qytConfig.Jobs, false, allowOverridingExistingBranches)
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", err.Error())
+				os.Exit(1)
+			}
+			return
+		}
+		signer, signerErr := qyt.CommitSigner(repo, qytConfig.Sign)
+		if signerErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", signerErr.Error())
+			os.Exit(1)
+		}
+		updates, applyErr := qyt.Apply(repo, qytConfig.Query, qytConfig.Refs(), qytConfig.Files(), qytConfig.CommitTemplate, qytConfig.NewBranchPrefix, author, committer, signer, qytConfig.Jobs, false, allowOverridingExistingBranches)
+		if applyErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", applyErr.Error())
+			for _, update := range updates {
+				_, _ = fmt.Fprintf(os.Stderr, "branch %s was left at %s\n", update.Name.Short(), update.New)
+			}
+			os.Exit(1)
+		}
+		if qytConfig.Push == "" {
+			return
+		}
+		report, pushErr := qyt.Push(repo, qytConfig.Push, updates, allowOverridingExistingBranches)
+		if pushErr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "push error: %s\n", pushErr.Error())
+			os.Exit(1)
+		}
+		if qytConfig.JSON {
+			err = json.NewEncoder(os.Stdout).Encode(report)
+		} else {
+			err = report.WriteText(os.Stdout)
+		}
 		if err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "apply error: %s\n", err.Error())
+			_, _ = fmt.Fprintf(os.Stderr, "push error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if report.Failed() {
 			os.Exit(1)
 		}
 		return
 	}
 }
 
-func getSignature(repo *git.Repository, now time.Time) (object.Signature, error) {
-	conf, err := repo.ConfigScoped(config.SystemScope)
-	if err != nil {
-		return object.Signature{}, fmt.Errorf("could not get git config: %w", err)
-	}
-	if conf.User.Name == "" {
-		return object.Signature{}, fmt.Errorf("git user name not set in config")
+// Exit codes for the check command. An assertion that does not hold is
+// distinguished from one that could not be evaluated.
+const (
+	exitCheckFailed = 1
+	exitCheckError  = 2
+)
+
+// setupErrorExitCode is the exit code when the command could not be started.
+// check reports it like any other error so it is not mistaken for a failed
+// assertion.
+func setupErrorExitCode(command string) int {
+	if command == "check" {
+		return exitCheckError
 	}
-	if conf.User.Email == "" {
-		return object.Signature{}, fmt.Errorf("git user email not set in config")
+	return 1
+}
+
+func resultCache(repo *git.Repository, c qyt.Configuration) (*qyt.ResultCache, error) {
+	if !c.Cache {
+		return nil, nil
 	}
-	return object.Signature{
-		Name:  conf.User.Name,
-		Email: conf.User.Email,
-		When:  now,
-	}, nil
+	return qyt.RepositoryResultCache(repo)
 }
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 
 	markdown "github.com/MichaelMure/go-term-markdown"
 )
@@ -14,11 +16,34 @@ import (
 type Configuration struct {
 	Query                    string `env:"QYT_QUERY_EXPRESSION"  flag:"q" default:"keys"         usage:"yq query expression it may be passed argument 1 after flags"`
 	BranchFilter             string `env:"QYT_BRANCH_FILTER"     flag:"b" default:".*"           usage:"regular expression to filter branches"`
+	RefSource                string `env:"QYT_REF_SOURCE"        flag:"s" default:"local"        usage:"branches to match: local, remote (refs/remotes/<remote>/*), or all"`
+	TagFilter                string `env:"QYT_TAG_FILTER"        flag:"t" default:""             usage:"regular expression to filter tags (when set without -b, branches are not matched)"`
+	Revisions                string `env:"QYT_REVISIONS"         flag:"rev" default:""           usage:"comma separated revisions like HEAD~3 or commit hashes (when set without -b, branches are not matched)"`
+	Reduce                   string `env:"QYT_REDUCE_EXPRESSION" flag:"reduce" default:""        usage:"yq expression evaluated on the results of every file on every branch collected into one sequence"`
+	Jobs                     int    `env:"QYT_JOBS"              flag:"j" default:"1"            usage:"number of files or branches to evaluate concurrently"`
 	FileNameFilter           string `env:"QYT_FILE_NAME_FILTER"  flag:"f" default:"(.+)\\.ya?ml" usage:"regular expression to filter file paths it may be passed argument 2 after flags"`
+	InputFormat              string `env:"QYT_INPUT_FORMAT"      flag:"input-format" default:""  usage:"format matched files are read and written in (yaml, json, toml, xml, props, hcl, env); detected from the file extension by default"`
 	GitRepositoryPath        string `env:"QYT_REPO_PATH"         flag:"r" default:"."            usage:"path to git repository"`
-	NewBranchPrefix          string `env:"QYT_NEW_BRANCH_PREFIX" flag:"p" default:"qyt/"         usage:"prefix for new branches"`
+	NewBranchPrefix          string `env:"QYT_NEW_BRANCH_PREFIX" flag:"p" default:"qyt/"         usage:"prefix or name template for new branches (like bump/{{.Branch}}-{{.Values.version}})"`
 	CommitToExistingBranches bool   `                            flag:"o" default:"false"        usage:"commit to existing branches instead of new branches"`
+	Join                     bool   `                            flag:"join" default:"false"     usage:"evaluate the expression once per branch on all matched files joined into one mapping keyed by path (also available as $files)"`
+	OnlyChanges              bool   `                            flag:"changes" default:"false"  usage:"log only results that differ from the result on the previous commit"`
+	Cache                    bool   `                            flag:"cache" default:"false"    usage:"reuse query results for unchanged files across runs (stored in .git/qyt-cache)"`
+	JSON                     bool   `                            flag:"json" default:"false"     usage:"write results and reports as JSON"`
+	DryRun                   bool   `                            flag:"dry-run" default:"false"  usage:"print a diff of the changes apply would commit without writing to the repository"`
+	Sign                     bool   `                            flag:"sign" default:"false"     usage:"sign commits with user.signingkey in the format set by gpg.format (commits are also signed when commit.gpgsign is true)"`
+	Worktree                 bool   `                            flag:"worktree" default:"false" usage:"write the changes apply makes on the checked out commit to the worktree instead of committing them"`
+	Stage                    bool   `                            flag:"stage" default:"false"    usage:"add the files -worktree changes to the index"`
+	Force                    bool   `                            flag:"force" default:"false"    usage:"let -worktree rewrite files with uncommitted changes"`
 	CommitTemplate           string `env:"QYT_COMMIT_TEMPLATE"   flag:"m" default:"run yq {{printf \"%q\" .Query}} on {{.Branch}}" usage:"commit message template"`
+	Push                     string `env:"QYT_PUSH_REMOTE"       flag:"push" default:""          usage:"remote to push the branches apply created or updated to (existing branches are forced with a lease)"`
+
+	// Args holds the positional arguments that follow the command.
+	Args []string
+
+	// diffQuery is the expression set with -q or the environment; it is "."
+	// when neither is set.
+	diffQuery string
 }
 
 //go:embed README.md
@@ -27,13 +52,19 @@ var readme string
 func LoadConfiguration(args []string) (Configuration, func(), error) {
 	fSet := flag.NewFlagSet("qyt", flag.ContinueOnError)
 
-	var c Configuration
+	var (
+		c           Configuration
+		defaultsErr error
+	)
 
 	v := reflect.ValueOf(&c)
 	t := v.Elem().Type()
 
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
 
 		envName := f.Tag.Get("env")
 		tagDefault := f.Tag.Get("default")
@@ -55,6 +86,12 @@ func LoadConfiguration(args []string) (Configuration, func(), error) {
 		case *bool:
 			dv := defaultValue == "true"
 			fSet.BoolVar(v, f.Tag.Get("flag"), dv, usage)
+		case *int:
+			dv, err := strconv.Atoi(defaultValue)
+			if err != nil && defaultsErr == nil {
+				defaultsErr = fmt.Errorf("could not parse %s: %w", envName, err)
+			}
+			fSet.IntVar(v, f.Tag.Get("flag"), dv, usage)
 		}
 	}
 
@@ -64,16 +101,54 @@ func LoadConfiguration(args []string) (Configuration, func(), error) {
 	}
 	fSet.Usage = usage
 
-	err := fSet.Parse(args)
-	if err != nil {
-		return c, usage, err
+	if defaultsErr != nil {
+		return c, usage, defaultsErr
+	}
+
+	// flags may follow positional arguments, as in "qyt log '.name' -b main"
+	var positional []string
+	for {
+		err := fSet.Parse(args)
+		if err != nil {
+			return c, usage, err
+		}
+		rest := fSet.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+
+	if c.TagFilter != "" || c.Revisions != "" {
+		branchFilterSet := os.Getenv("QYT_BRANCH_FILTER") != ""
+		fSet.Visit(func(f *flag.Flag) {
+			branchFilterSet = branchFilterSet || f.Name == "b"
+		})
+		if !branchFilterSet {
+			c.BranchFilter = ""
+		}
 	}
 
-	if fSet.Arg(0) == "help" {
+	if len(positional) > 0 && positional[0] == "help" {
 		return c, usage, errors.New("help requested")
 	}
 
-	args = fSet.Args()
+	c.diffQuery = "."
+	querySet := os.Getenv("QYT_QUERY_EXPRESSION") != ""
+	fSet.Visit(func(f *flag.Flag) {
+		querySet = querySet || f.Name == "q"
+	})
+	if querySet {
+		c.diffQuery = c.Query
+	}
+
+	c.Args = positional
+	args = positional
 	if len(args) > 0 {
 		c.Query = args[0]
 	}
@@ -83,3 +158,35 @@ func LoadConfiguration(args []string) (Configuration, func(), error) {
 
 	return c, usage, nil
 }
+
+// DiffQuery returns the expression for the diff command. Its positional
+// arguments are refs, so the expression is only read from -q or the
+// environment. Without either, whole files are compared.
+func (c Configuration) DiffQuery() string {
+	return c.diffQuery
+}
+
+// Files returns the file filter for the configured file name pattern, input format, and join mode.
+func (c Configuration) Files() FileFilter {
+	return FileFilter{
+		Pattern: c.FileNameFilter,
+		Format:  c.InputFormat,
+		Join:    c.Join,
+	}
+}
+
+// Refs returns the reference filter for the configured branches, tags, and revisions.
+func (c Configuration) Refs() RefFilter {
+	var revisions []string
+	for _, rev := range strings.Split(c.Revisions, ",") {
+		if rev = strings.TrimSpace(rev); rev != "" {
+			revisions = append(revisions, rev)
+		}
+	}
+	return RefFilter{
+		Branches:  c.BranchFilter,
+		Source:    RefSource(c.RefSource),
+		Tags:      c.TagFilter,
+		Revisions: revisions,
+	}
+}
@@ -0,0 +1,84 @@
+package qyt
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	diffutil "github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// fileChange is the content of a file before and after applying an
+// expression. Before is nil when the file is created and After is nil when
+// it is deleted.
+type fileChange struct {
+	Name          string
+	Mode          filemode.FileMode
+	Before, After []byte
+}
+
+// writeUnifiedDiff writes the changes as a git-style unified diff with three
+// lines of context.
+func writeUnifiedDiff(w io.Writer, changes []fileChange) error {
+	patch := filesPatch{}
+	for _, change := range changes {
+		patch.files = append(patch.files, change)
+	}
+	return diff.NewUnifiedEncoder(w, diff.DefaultContextLines).Encode(patch)
+}
+
+type filesPatch struct {
+	files []diff.FilePatch
+}
+
+func (patch filesPatch) FilePatches() []diff.FilePatch { return patch.files }
+func (patch filesPatch) Message() string               { return "" }
+
+func (change fileChange) IsBinary() bool { return false }
+
+func (change fileChange) Files() (from, to diff.File) {
+	if change.Before != nil {
+		from = patchFile{path: change.Name, mode: change.Mode, content: change.Before}
+	}
+	if change.After != nil {
+		to = patchFile{path: change.Name, mode: change.Mode, content: change.After}
+	}
+	return from, to
+}
+
+func (change fileChange) Chunks() []diff.Chunk {
+	var chunks []diff.Chunk
+	for _, d := range diffutil.Do(string(change.Before), string(change.After)) {
+		op := diff.Equal
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			op = diff.Add
+		case diffmatchpatch.DiffDelete:
+			op = diff.Delete
+		}
+		chunks = append(chunks, patchChunk{content: d.Text, op: op})
+	}
+	return chunks
+}
+
+type patchFile struct {
+	path    string
+	mode    filemode.FileMode
+	content []byte
+}
+
+func (file patchFile) Hash() plumbing.Hash {
+	return plumbing.ComputeHash(plumbing.BlobObject, file.content)
+}
+func (file patchFile) Mode() filemode.FileMode { return file.mode }
+func (file patchFile) Path() string            { return file.path }
+
+type patchChunk struct {
+	content string
+	op      diff.Operation
+}
+
+func (chunk patchChunk) Content() string      { return chunk.content }
+func (chunk patchChunk) Type() diff.Operation { return chunk.op }
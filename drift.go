@@ -0,0 +1,155 @@
+package qyt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// DriftReport groups the matched refs by the value the expression returns for
+// each file.
+type DriftReport struct {
+	Files []FileDrift `json:"files"`
+}
+
+// FileDrift holds the distinct values of a file across refs. Groups are
+// ordered from the most to the least common value; every group after the
+// first is an outlier. Missing lists the refs that do not have the file.
+type FileDrift struct {
+	File    string       `json:"file"`
+	Groups  []DriftGroup `json:"groups"`
+	Missing []string     `json:"missing"`
+}
+
+// DriftGroup is a value and the refs that have it. Value holds the result
+// nodes encoded as compact JSON, one per line.
+type DriftGroup struct {
+	Value string   `json:"value"`
+	Refs  []string `json:"refs"`
+}
+
+// Drifted reports whether the file has more than one value or is missing on
+// some refs.
+func (drift FileDrift) Drifted() bool {
+	return len(drift.Groups) > 1 || len(drift.Missing) > 0
+}
+
+// WriteTable writes a row for each value of each file. Outliers and refs
+// missing the file are marked in the STATUS column.
+func (report DriftReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FILE\tSTATUS\tREFS\tVALUE")
+	for _, drift := range report.Files {
+		for i, group := range drift.Groups {
+			status := "common"
+			if i > 0 {
+				status = "outlier"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", drift.File, status, strings.Join(group.Refs, ", "), strings.ReplaceAll(group.Value, "\n", " "))
+		}
+		if len(drift.Missing) > 0 {
+			_, _ = fmt.Fprintf(tw, "%s\tmissing\t%s\n", drift.File, strings.Join(drift.Missing, ", "))
+		}
+	}
+	return tw.Flush()
+}
+
+// Drift evaluates the expression on each matched file on each matched ref
+// and groups the refs by the value they have. Values are compared
+// structurally, so formatting and the order of mapping keys do not count as
+// drift.
+func Drift(repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, cache *ResultCache, jobs int) (DriftReport, error) {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	refs, err := MatchingRefs(repo, refFilter, false)
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("failed to match refs: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return DriftReport{}, fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	type refValue struct {
+		fingerprints, values []string
+	}
+
+	var (
+		fileNames []string
+		values    = make(map[string]map[plumbing.ReferenceName]*refValue)
+		errs      []error
+	)
+	for result, err := range results(repo, yqExpression, yqExp, refs, fp, fileFilter.Format, fileFilter.Join, cache, jobs) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		fileValues, ok := values[result.File]
+		if !ok {
+			fileValues = make(map[plumbing.ReferenceName]*refValue)
+			values[result.File] = fileValues
+			fileNames = append(fileNames, result.File)
+		}
+		value, ok := fileValues[result.Ref.Name()]
+		if !ok {
+			value = new(refValue)
+			fileValues[result.Ref.Name()] = value
+		}
+		for _, node := range result.Nodes {
+			encoded, encodeErr := compactJSON(node)
+			if encodeErr != nil {
+				return DriftReport{}, encodeErr
+			}
+			value.values = append(value.values, encoded)
+			value.fingerprints = append(value.fingerprints, nodeFingerprint(node))
+		}
+	}
+	if len(errs) > 0 {
+		return DriftReport{}, errors.Join(errs...)
+	}
+
+	slices.Sort(fileNames)
+
+	report := DriftReport{Files: []FileDrift{}}
+	for _, fileName := range fileNames {
+		drift := FileDrift{File: fileName, Groups: []DriftGroup{}, Missing: []string{}}
+
+		groupIndex := make(map[string]int)
+		for _, ref := range refs {
+			value, ok := values[fileName][ref.Name()]
+			if !ok {
+				drift.Missing = append(drift.Missing, ref.Name().Short())
+				continue
+			}
+			key := strings.Join(value.fingerprints, "\x00")
+			i, ok := groupIndex[key]
+			if !ok {
+				i = len(drift.Groups)
+				groupIndex[key] = i
+				drift.Groups = append(drift.Groups, DriftGroup{Value: strings.Join(value.values, "\n")})
+			}
+			drift.Groups[i].Refs = append(drift.Groups[i].Refs, ref.Name().Short())
+		}
+
+		// the stable sort keeps groups of the same size in ref order
+		slices.SortStableFunc(drift.Groups, func(a, b DriftGroup) int {
+			return len(b.Refs) - len(a.Refs)
+		})
+
+		report.Files = append(report.Files, drift)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,69 @@
+package qyt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrift(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	sig := someSignature()
+	commitOnBranch := func(branch, from string, files map[string]string) {
+		t.Helper()
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(from)}))
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Create: true, Branch: plumbing.NewBranchReferenceName(branch)}))
+		for name, contents := range files {
+			createFile(t, wt.Filesystem, name, contents)
+			_, addErr := wt.Add(name)
+			assert.NoError(t, addErr)
+		}
+		_, commitErr := wt.Commit("update "+branch, &git.CommitOptions{Author: &sig, Committer: &sig})
+		assert.NoError(t, commitErr)
+	}
+
+	createInitialCommitOnMain(t, wt)
+	commitOnBranch("rel/2.1", "main", map[string]string{"chart.yaml": "dependencies:\n  redis: 1.0\n  pg: 2.0\n"})
+	commitOnBranch("rel/2.2", "main", map[string]string{"chart.yaml": "dependencies:\n  redis: 1.1\n  pg: 2.0\n"})
+	// same value with a different key order and layout
+	commitOnBranch("rel/2.3", "main", map[string]string{"chart.yaml": "dependencies: {pg: 2.0, redis: 1.0}\n"})
+	commitOnBranch("rel/2.4", "main", map[string]string{"values.yaml": "{}\n"})
+
+	report, err := Drift(repo, `.dependencies`, RefFilter{Branches: "^rel/"}, FileFilter{Pattern: `chart\.yaml`}, nil, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, DriftReport{Files: []FileDrift{{
+		File: "chart.yaml",
+		Groups: []DriftGroup{
+			{Value: `{"redis":1,"pg":2}`, Refs: []string{"rel/2.1", "rel/2.3"}},
+			{Value: `{"redis":1.1,"pg":2}`, Refs: []string{"rel/2.2"}},
+		},
+		Missing: []string{"rel/2.4"},
+	}}}, report)
+	assert.True(t, report.Files[0].Drifted())
+
+	var out bytes.Buffer
+	assert.NoError(t, report.WriteTable(&out))
+	assert.Equal(t, ""+
+		"FILE        STATUS   REFS              VALUE\n"+
+		"chart.yaml  common   rel/2.1, rel/2.3  {\"redis\":1,\"pg\":2}\n"+
+		"chart.yaml  outlier  rel/2.2           {\"redis\":1.1,\"pg\":2}\n"+
+		"chart.yaml  missing  rel/2.4\n", out.String())
+}
@@ -0,0 +1,245 @@
+package qyt
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// envFormat reads and writes .env files. yq does not have a dotenv format
+// but KEY=value lines are valid properties.
+var envFormat = &yqlib.Format{
+	FormalName: "env",
+	Names:      []string{"dotenv"},
+	EncoderFactory: func() yqlib.Encoder {
+		return yqlib.NewPropertiesEncoder(yqlib.PropertiesPreferences{
+			UnwrapScalar:      true,
+			KeyValueSeparator: "=",
+		})
+	},
+	DecoderFactory: yqlib.NewPropertiesDecoder,
+}
+
+// FileFormat returns the format used to read and write filename. When
+// formatName is empty, the format is detected from the file extension and
+// files with unknown extensions are read as YAML.
+func FileFormat(filename, formatName string) (*yqlib.Format, error) {
+	if formatName != "" {
+		format, err := formatFromName(formatName)
+		if err != nil {
+			return nil, err
+		}
+		if format.DecoderFactory == nil || format.EncoderFactory == nil {
+			return nil, fmt.Errorf("format %q can not be used to read and write files", formatName)
+		}
+		return format, nil
+	}
+
+	base := path.Base(filename)
+	if base == ".env" || strings.HasPrefix(base, ".env.") {
+		return envFormat, nil
+	}
+
+	format, err := formatFromName(yqlib.FormatStringFromFilename(filename))
+	if err != nil || format.DecoderFactory == nil || format.EncoderFactory == nil {
+		return yqlib.YamlFormat, nil
+	}
+	return format, nil
+}
+
+func formatFromName(name string) (*yqlib.Format, error) {
+	if envFormat.MatchesName(name) {
+		return envFormat, nil
+	}
+	return yqlib.FormatFromString(name)
+}
+
+func newDecoder(format *yqlib.Format) yqlib.Decoder {
+	if format == yqlib.YamlFormat {
+		return yqlib.NewYamlDecoder(yqlib.NewDefaultYamlPreferences())
+	}
+	return format.DecoderFactory()
+}
+
+// fileStyle is the layout of a file that is kept when it is rewritten.
+type fileStyle struct {
+	Indent                int
+	CompactSequenceIndent bool
+	DocumentSeparators    bool
+	TrailingNewline       bool
+
+	// IndentTabs is set for JSON files indented with tabs; Indent is then
+	// the number of tabs per level.
+	IndentTabs bool
+	// SeparatorSpaces is set for single line JSON files with a space after
+	// each colon and comma, like {"a": 1, "b": 2}.
+	SeparatorSpaces bool
+}
+
+// detectFileStyle guesses the layout of a file so that rewriting it only
+// changes the lines an expression changed. Files without any nested values
+// get an indent of 2.
+func detectFileStyle(format *yqlib.Format, in []byte) fileStyle {
+	style := fileStyle{
+		Indent:          2,
+		TrailingNewline: len(in) == 0 || bytes.HasSuffix(in, []byte("\n")),
+	}
+
+	switch format {
+	case yqlib.YamlFormat:
+		style.DocumentSeparators = yamlSeparatorLineExp.Match(in)
+		style.Indent, style.CompactSequenceIndent = yamlIndent(in)
+	case yqlib.JSONFormat:
+		style.Indent, style.IndentTabs = jsonIndent(in)
+		style.SeparatorSpaces = style.Indent == 0 && jsonSeparatorSpaces(in)
+	}
+	return style
+}
+
+// restore applies the parts of the style the encoder does not support to an
+// encoded file.
+func (style fileStyle) restore(encoded []byte) []byte {
+	if style.IndentTabs {
+		lines := bytes.SplitAfter(encoded, []byte("\n"))
+		for i, line := range lines {
+			trimmed := bytes.TrimLeft(line, " ")
+			lines[i] = append(bytes.Repeat([]byte("\t"), len(line)-len(trimmed)), trimmed...)
+		}
+		encoded = bytes.Join(lines, nil)
+	}
+	if style.SeparatorSpaces {
+		encoded = spaceJSONSeparators(encoded)
+	}
+	if !style.TrailingNewline {
+		encoded = bytes.TrimSuffix(encoded, []byte("\n"))
+	}
+	return encoded
+}
+
+var yamlSeparatorLineExp = regexp.MustCompile(`(?m)^---(\s|$)`)
+
+// yamlIndent returns the indent of the first nested mapping and whether
+// sequences in mappings are indented less than mappings, like
+//
+//	key:
+//	- item
+func yamlIndent(in []byte) (int, bool) {
+	var (
+		indent, sequenceIndent = 0, -1
+		previousIndent         = -1
+		previousOpensBlock     bool
+	)
+	for _, line := range strings.Split(string(in), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || yamlSeparatorLineExp.MatchString(line) {
+			continue
+		}
+		lineIndent := len(line) - len(strings.TrimLeft(line, " "))
+		isSequenceItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+
+		if previousOpensBlock && lineIndent >= previousIndent {
+			if isSequenceItem && sequenceIndent < 0 {
+				sequenceIndent = lineIndent - previousIndent
+			} else if !isSequenceItem && indent == 0 && lineIndent > previousIndent {
+				indent = lineIndent - previousIndent
+			}
+		}
+
+		previousIndent = lineIndent
+		previousOpensBlock = strings.HasSuffix(trimmed, ":")
+	}
+
+	if indent == 0 {
+		indent = 2
+		if sequenceIndent > 0 {
+			indent = sequenceIndent
+		}
+	}
+	return indent, sequenceIndent >= 0 && sequenceIndent < indent
+}
+
+// jsonIndent returns the number of spaces (or tabs when tabs is true) before
+// the first indented line or 0 when the JSON is written on a single line. New
+// files are indented with 2 spaces.
+func jsonIndent(in []byte) (indent int, tabs bool) {
+	if len(bytes.TrimSpace(in)) == 0 {
+		return 2, false
+	}
+	lines := strings.Split(strings.TrimSpace(string(in)), "\n")
+	if len(lines) < 2 {
+		return 0, false
+	}
+	for _, line := range lines[1:] {
+		if lineIndent := len(line) - len(strings.TrimLeft(line, "\t")); lineIndent > 0 {
+			return lineIndent, true
+		}
+		if lineIndent := len(line) - len(strings.TrimLeft(line, " ")); lineIndent > 0 {
+			return lineIndent, false
+		}
+	}
+	return 2, false
+}
+
+// jsonSeparatorSpaces reports whether the first colon or comma outside a
+// string is followed by a space.
+func jsonSeparatorSpaces(in []byte) bool {
+	inString, escaped := false, false
+	for i, c := range in {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && (c == ':' || c == ','):
+			return i+1 < len(in) && in[i+1] == ' '
+		}
+	}
+	return false
+}
+
+// spaceJSONSeparators adds a space after each colon and comma outside
+// strings of compact JSON.
+func spaceJSONSeparators(in []byte) []byte {
+	out := make([]byte, 0, len(in)+len(in)/4)
+	inString, escaped := false, false
+	for _, c := range in {
+		out = append(out, c)
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && (c == ':' || c == ','):
+			out = append(out, ' ')
+		}
+	}
+	return out
+}
+
+// newFileEncoder returns the encoder used to write a file back in its format.
+func newFileEncoder(format *yqlib.Format, style fileStyle) yqlib.Encoder {
+	switch format {
+	case yqlib.YamlFormat:
+		return yqlib.NewYamlEncoder(yqlib.YamlPreferences{
+			Indent:                style.Indent,
+			CompactSequenceIndent: style.CompactSequenceIndent,
+			PrintDocSeparators:    style.DocumentSeparators,
+			UnwrapScalar:          true,
+		})
+	case yqlib.JSONFormat:
+		return yqlib.NewJSONEncoder(yqlib.JsonPreferences{
+			Indent:       style.Indent,
+			UnwrapScalar: true,
+		})
+	default:
+		return format.EncoderFactory()
+	}
+}
@@ -0,0 +1,205 @@
+package qyt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileFormat(t *testing.T) {
+	for _, tt := range []struct {
+		fileName, override string
+		expected           *yqlib.Format
+	}{
+		{fileName: "values.yaml", expected: yqlib.YamlFormat},
+		{fileName: "values.yml", expected: yqlib.YamlFormat},
+		{fileName: "package.json", expected: yqlib.JSONFormat},
+		{fileName: "Cargo.toml", expected: yqlib.TomlFormat},
+		{fileName: "pom.xml", expected: yqlib.XMLFormat},
+		{fileName: "app.properties", expected: yqlib.PropertiesFormat},
+		{fileName: "main.tf", expected: yqlib.HclFormat},
+		{fileName: "config/.env", expected: envFormat},
+		{fileName: "config/.env.production", expected: envFormat},
+		{fileName: "Dockerfile", expected: yqlib.YamlFormat},
+		{fileName: "values.txt", override: "json", expected: yqlib.JSONFormat},
+	} {
+		t.Run(tt.fileName, func(t *testing.T) {
+			format, err := FileFormat(tt.fileName, tt.override)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected.FormalName, format.FormalName)
+		})
+	}
+
+	t.Run("unknown override", func(t *testing.T) {
+		_, err := FileFormat("values.yml", "banana")
+		assert.Error(t, err)
+	})
+}
+
+func TestRewriteFile_preserves_style(t *testing.T) {
+	for _, tt := range []struct {
+		name, fileName, in, exp, expected string
+	}{
+		{
+			name:     "four space indent",
+			fileName: "values.yml",
+			in:       "image:\n    name: app\n    tag: '1.0'\n",
+			exp:      `.image.tag = "1.1"`,
+			expected: "image:\n    name: app\n    tag: '1.1'\n",
+		},
+		{
+			name:     "compact sequences",
+			fileName: "values.yml",
+			in:       "ports:\n- 80\n- 443\nname: \"app\"\n",
+			exp:      `.name = "web"`,
+			expected: "ports:\n- 80\n- 443\nname: \"web\"\n",
+		},
+		{
+			name:     "no document separator",
+			fileName: "values.yml",
+			in:       "# config\nname: app\n",
+			exp:      `.name = "web"`,
+			expected: "# config\nname: web\n",
+		},
+		{
+			name:     "document separators",
+			fileName: "values.yml",
+			in:       "---\nname: app\n---\nname: db\n",
+			exp:      `.name |= . + "-1"`,
+			expected: "---\nname: app-1\n---\nname: db-1\n",
+		},
+		{
+			name:     "no trailing newline",
+			fileName: "values.yml",
+			in:       "name: app",
+			exp:      `.name = "web"`,
+			expected: "name: web",
+		},
+		{
+			name:     "json indent",
+			fileName: "package.json",
+			in:       "{\n    \"version\": \"1.0.0\"\n}\n",
+			exp:      `.version = "1.0.1"`,
+			expected: "{\n    \"version\": \"1.0.1\"\n}\n",
+		},
+		{
+			name:     "single line json",
+			fileName: "package.json",
+			in:       `{"version":"1.0.0"}`,
+			exp:      `.version = "1.0.1"`,
+			expected: `{"version":"1.0.1"}`,
+		},
+		{
+			name:     "tab indented json",
+			fileName: "package.json",
+			in:       "{\n\t\"version\": \"1.0.0\",\n\t\"scripts\": {\n\t\t\"test\": \"go test\"\n\t}\n}\n",
+			exp:      `.version = "1.0.1"`,
+			expected: "{\n\t\"version\": \"1.0.1\",\n\t\"scripts\": {\n\t\t\"test\": \"go test\"\n\t}\n}\n",
+		},
+		{
+			name:     "single line json with spaces",
+			fileName: "package.json",
+			in:       `{"version": "1.0.0", "tags": ["a:b, c", "d"]}`,
+			exp:      `.version = "1.0.1"`,
+			expected: `{"version": "1.0.1", "tags": ["a:b, c", "d"]}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			exp, err := yqlib.ExpressionParser.ParseExpression(tt.exp)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var out bytes.Buffer
+			if !assert.NoError(t, RewriteFile(&out, strings.NewReader(tt.in), exp, tt.fileName, "", nil)) {
+				return
+			}
+			assert.Equal(t, tt.expected, out.String())
+		})
+	}
+}
+
+func TestApply_file_formats(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	signature := someSignature()
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	files := map[string]string{
+		"package.json": "{\n  \"name\": \"app\",\n  \"version\": \"1.0\"\n}\n",
+		"Cargo.toml":   "name = \"app\"\nversion = \"1.0\"\n",
+		"values.yml":   "name: app\nversion: \"1.0\"\n",
+		".env":         "name=app\nversion=1.0\n",
+	}
+	for name, contents := range files {
+		createFile(t, wt.Filesystem, name, contents)
+		_, addErr := wt.Add(name)
+		if !assert.NoError(t, addErr) {
+			return
+		}
+	}
+	_, commitErr := wt.Commit("add config", &git.CommitOptions{Author: &signature, Committer: &signature})
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+
+	t.Run("query", func(t *testing.T) {
+		var out bytes.Buffer
+		queryErr := Query(&out, repo, `$filename + " " + .version`, RefFilter{Branches: "master"}, FileFilter{Pattern: `.*\.(json|toml|ya?ml)|\.env`}, nil, 1, false, false)
+		assert.NoError(t, queryErr)
+		assert.Equal(t, ".env 1.0\nCargo.toml 1.0\npackage.json 1.0\nvalues.yml 1.0\n", out.String())
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		_, applyErr := Apply(repo,
+			`.version = "2.0"`,
+			RefFilter{Branches: "master"},
+			FileFilter{Pattern: `.*\.(json|toml|ya?ml)|\.env`}, "bump version", "bump/",
+			signature, signature, nil,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, applyErr) {
+			return
+		}
+
+		ref, refErr := repo.Reference(plumbing.NewBranchReferenceName("bump/master"), true)
+		if !assert.NoError(t, refErr) {
+			return
+		}
+		commit, getCommitErr := repo.CommitObject(ref.Hash())
+		if !assert.NoError(t, getCommitErr) {
+			return
+		}
+
+		for name, expected := range map[string]string{
+			"package.json": "{\n  \"name\": \"app\",\n  \"version\": \"2.0\"\n}\n",
+			"Cargo.toml":   "name = \"app\"\nversion = \"2.0\"\n",
+			"values.yml":   "name: app\nversion: \"2.0\"\n",
+			".env":         "name=app\nversion=2.0\n",
+		} {
+			file, fileErr := commit.File(name)
+			if !assert.NoError(t, fileErr) {
+				continue
+			}
+			contents, contentsErr := file.Contents()
+			assert.NoError(t, contentsErr)
+			assert.Equal(t, expected, contents, name)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package qyt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// gitConfigs returns the local, global, and system git config of the
+// repository in the order git looks values up in them. Like git, the system
+// config is skipped when GIT_CONFIG_NOSYSTEM is true.
+func gitConfigs(repo *git.Repository) ([]*config.Config, error) {
+	local, err := repo.Storer.Config()
+	if err != nil {
+		return nil, fmt.Errorf("could not read repository config: %w", err)
+	}
+	// Marshal copies fields like User.Name into Raw when they were set on
+	// the struct instead of read from a file
+	if _, err := local.Marshal(); err != nil {
+		return nil, fmt.Errorf("could not read repository config: %w", err)
+	}
+	configs := []*config.Config{local}
+	scopes := []config.Scope{config.GlobalScope, config.SystemScope}
+	if gitBool(os.Getenv("GIT_CONFIG_NOSYSTEM")) {
+		scopes = scopes[:1]
+	}
+	for _, scope := range scopes {
+		c, err := config.LoadConfig(scope)
+		if err != nil {
+			return nil, fmt.Errorf("could not read git config: %w", err)
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// gitBool reports whether value is one of the values git reads as true.
+func gitBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// gitConfigOption returns the value of section.key from the first config that
+// sets it.
+func gitConfigOption(configs []*config.Config, section, key string) string {
+	for _, c := range configs {
+		if c.Raw == nil || !c.Raw.HasSection(section) {
+			continue
+		}
+		if s := c.Raw.Section(section); s.HasOption(key) {
+			return s.Option(key)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,99 @@
+package qyt
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Identity returns the author and committer of new commits the way git
+// resolves them. The name and email are read from GIT_AUTHOR_NAME and
+// GIT_AUTHOR_EMAIL (GIT_COMMITTER_NAME and GIT_COMMITTER_EMAIL for the
+// committer), then author.name and author.email (committer.*), then user.name
+// and user.email, from the local, global, and system git config, and finally
+// the email from EMAIL. The time is read from GIT_AUTHOR_DATE
+// (GIT_COMMITTER_DATE) and is now when it is not set.
+func Identity(repo *git.Repository, now time.Time) (author, committer object.Signature, err error) {
+	configs, err := gitConfigs(repo)
+	if err != nil {
+		return object.Signature{}, object.Signature{}, err
+	}
+
+	identity := func(role string) (object.Signature, error) {
+		env := "GIT_" + strings.ToUpper(role) + "_"
+		signature := object.Signature{
+			Name: cmp.Or(
+				os.Getenv(env+"NAME"),
+				gitConfigOption(configs, role, "name"),
+				gitConfigOption(configs, "user", "name"),
+			),
+			Email: cmp.Or(
+				os.Getenv(env+"EMAIL"),
+				gitConfigOption(configs, role, "email"),
+				gitConfigOption(configs, "user", "email"),
+				os.Getenv("EMAIL"),
+			),
+			When: now,
+		}
+		if signature.Name == "" {
+			return object.Signature{}, fmt.Errorf("%s name is not set: set user.name in git config or %sNAME", role, env)
+		}
+		if signature.Email == "" {
+			return object.Signature{}, fmt.Errorf("%s email is not set: set user.email in git config or %sEMAIL", role, env)
+		}
+		if date := os.Getenv(env + "DATE"); date != "" {
+			when, err := parseGitDate(date)
+			if err != nil {
+				return object.Signature{}, fmt.Errorf("could not parse %sDATE: %w", env, err)
+			}
+			signature.When = when
+		}
+		return signature, nil
+	}
+
+	if author, err = identity("author"); err != nil {
+		return object.Signature{}, object.Signature{}, err
+	}
+	if committer, err = identity("committer"); err != nil {
+		return object.Signature{}, object.Signature{}, err
+	}
+	return author, committer, nil
+}
+
+// parseGitDate parses the date formats git accepts in GIT_AUTHOR_DATE: the
+// internal "<unix seconds> <offset>" format (optionally prefixed with "@"),
+// RFC 2822, and ISO 8601.
+func parseGitDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	seconds, offset, hasOffset := strings.Cut(strings.TrimPrefix(date, "@"), " ")
+	if unix, err := strconv.ParseInt(seconds, 10, 64); err == nil {
+		when := time.Unix(unix, 0)
+		if !hasOffset {
+			return when.UTC(), nil
+		}
+		zone, err := time.Parse("-0700", offset)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time zone offset %q", offset)
+		}
+		return when.In(zone.Location()), nil
+	}
+	for _, layout := range []string{
+		time.RFC1123Z,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		time.RFC3339,
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02 15:04:05 -0700",
+		"2006-01-02 15:04:05 -07:00",
+	} {
+		if when, err := time.Parse(layout, date); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format %q", date)
+}
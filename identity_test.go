@@ -0,0 +1,113 @@
+package qyt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	for _, name := range []string{
+		"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_AUTHOR_DATE",
+		"GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL", "GIT_COMMITTER_DATE",
+		"EMAIL",
+	} {
+		t.Setenv(name, "")
+	}
+
+	repo, initErr := git.Init(memory.NewStorage(), nil)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+	now := time.Unix(1622680178, 0)
+
+	_, _, err := Identity(repo, now)
+	assert.ErrorContains(t, err, "author name is not set")
+
+	t.Run("global config", func(t *testing.T) {
+		if !assert.NoError(t, os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[user]\n\tname = Global\n\temail = global@example.com\n[committer]\n\tname = Bot\n"), 0o600)) {
+			return
+		}
+
+		author, committer, err := Identity(repo, now)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "Global", author.Name)
+		assert.Equal(t, "global@example.com", author.Email)
+		assert.Equal(t, now, author.When)
+		assert.Equal(t, "Bot", committer.Name)
+		assert.Equal(t, "global@example.com", committer.Email)
+	})
+
+	t.Run("local config", func(t *testing.T) {
+		c, err := repo.Config()
+		if !assert.NoError(t, err) {
+			return
+		}
+		c.User.Name = "Local"
+		if !assert.NoError(t, repo.SetConfig(c)) {
+			return
+		}
+
+		author, committer, err := Identity(repo, now)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "Local", author.Name)
+		assert.Equal(t, "global@example.com", author.Email)
+		// committer.name is used over user.name from any scope
+		assert.Equal(t, "Bot", committer.Name)
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("GIT_AUTHOR_NAME", "Env Author")
+		t.Setenv("GIT_AUTHOR_DATE", "@1500000000 +0200")
+		t.Setenv("GIT_COMMITTER_EMAIL", "ci@example.com")
+
+		author, committer, err := Identity(repo, now)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "Env Author", author.Name)
+		assert.Equal(t, int64(1500000000), author.When.Unix())
+		_, offset := author.When.Zone()
+		assert.Equal(t, 2*60*60, offset)
+		assert.Equal(t, "Bot", committer.Name)
+		assert.Equal(t, "ci@example.com", committer.Email)
+		assert.Equal(t, now, committer.When)
+
+		t.Setenv("GIT_COMMITTER_DATE", "yesterday")
+		_, _, err = Identity(repo, now)
+		assert.ErrorContains(t, err, "could not parse GIT_COMMITTER_DATE")
+	})
+}
+
+func TestParseGitDate(t *testing.T) {
+	for _, date := range []string{
+		"1622680178 -0700",
+		"@1622680178 -0700",
+		"Wed, 2 Jun 2021 17:29:38 -0700",
+		"2021-06-02T17:29:38-07:00",
+		"2021-06-02 17:29:38 -0700",
+	} {
+		t.Run(date, func(t *testing.T) {
+			when, err := parseGitDate(date)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, int64(1622680178), when.Unix())
+			_, offset := when.Zone()
+			assert.Equal(t, -7*60*60, offset)
+		})
+	}
+}
@@ -111,4 +111,18 @@ func TestCreateNewTreeWithFiles(t *testing.T) {
 			assert.Equal(t, filepath.Base(f.Name), "file.txt")
 		}
 	})
+
+	t.Run("file named like a directory", func(t *testing.T) {
+		parent := &object.Tree{Entries: []object.TreeEntry{{Name: "dir", Mode: filemode.Dir}}}
+
+		_, _, err := createNewTreeWithFiles(parent, []memoryFile{{Name: "dir"}})
+		assert.ErrorContains(t, err, `could not write file "dir": it is a directory`)
+	})
+
+	t.Run("directory named like a file", func(t *testing.T) {
+		parent := &object.Tree{Entries: []object.TreeEntry{{Name: "file.txt", Mode: filemode.Regular}}}
+
+		_, _, err := createNewTreeWithFiles(parent, []memoryFile{{Name: "file.txt/new.txt"}})
+		assert.ErrorContains(t, err, `could not write files in "file.txt": it is not a directory`)
+	})
 }
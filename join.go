@@ -0,0 +1,228 @@
+package qyt
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// joinedFile is a matched file loaded into a joined document.
+type joinedFile struct {
+	file      *object.File
+	in        []byte
+	format    *yqlib.Format
+	documents int
+	// null is set when the file had no documents or a null document, so
+	// a null value does not delete it.
+	null bool
+}
+
+// joinFiles reads the files and decodes them into one mapping keyed by path.
+// A file with one document is the document; a file with several documents is
+// a sequence of them.
+func joinFiles(lock sync.Locker, files []*object.File, inputFormat string) (*yqlib.CandidateNode, []joinedFile, error) {
+	root := &yqlib.CandidateNode{Kind: yqlib.MappingNode, Tag: "!!map", EvaluateTogether: true}
+	joined := make([]joinedFile, 0, len(files))
+	for _, file := range files {
+		in, err := readFile(lock, file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read file %q: %s", file.Name, err)
+		}
+		format, err := FileFormat(file.Name, inputFormat)
+		if err != nil {
+			return nil, nil, err
+		}
+		documents, err := decodeDocuments(bytes.NewReader(in), file.Name, inputFormat)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not decode file %q: %s", file.Name, err)
+		}
+
+		var value *yqlib.CandidateNode
+		switch len(documents) {
+		case 0:
+			value = &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!null", Value: "null"}
+		case 1:
+			value = documents[0]
+		default:
+			value = &yqlib.CandidateNode{Kind: yqlib.SequenceNode, Tag: "!!seq"}
+			for _, document := range documents {
+				document.Parent = value
+				document.Key = &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!int", Value: fmt.Sprint(len(value.Content))}
+				value.Content = append(value.Content, document)
+			}
+		}
+
+		key := &yqlib.CandidateNode{Kind: yqlib.ScalarNode, Tag: "!!str", Value: file.Name, IsMapKey: true, Parent: root}
+		value.Parent, value.Key = root, key
+		root.Content = append(root.Content, key, value)
+
+		joined = append(joined, joinedFile{file: file, in: in, format: format, documents: len(documents), null: isNullNode(value)})
+	}
+	return root, joined, nil
+}
+
+// evaluateJoined evaluates the expression on a joined document. The document
+// is also available to the expression as $files.
+func evaluateJoined(root *yqlib.CandidateNode, exp *yqlib.ExpressionNode, variables map[string]string, values *yqlib.CandidateNode) ([]*yqlib.CandidateNode, error) {
+	nodes := list.New()
+	nodes.PushBack(root)
+
+	ctx := yqlib.Context{
+		MatchingNodes: nodes,
+	}
+	for k, v := range variables {
+		ctx.SetVariable(k, scopeVariable(v))
+	}
+	files := list.New()
+	files.PushBack(root)
+	ctx.SetVariable("files", files)
+	ctx.SetVariable("documentIndex", scopeValue("0"))
+	setValuesVariable(&ctx, values)
+
+	result, err := yqlib.NewDataTreeNavigator().GetMatchingNodes(ctx, cloneExpression(exp))
+	if err != nil {
+		return nil, fmt.Errorf("yq operation failed: %w", err)
+	}
+
+	var matches []*yqlib.CandidateNode
+	for el := result.MatchingNodes.Front(); el != nil; el = el.Next() {
+		matches = append(matches, el.Value.(*yqlib.CandidateNode))
+	}
+	return matches, nil
+}
+
+// rewriteJoinedFiles evaluates the expression on the joined files and returns
+// the files it changes. The expression must return the joined document (like
+// an assignment does). Matched files whose value was set to null are deleted
+// and files whose key was removed are left unchanged. New keys are created as
+// files in the format of their extension; a key may not name a file in commit
+// that was not matched or a directory.
+func rewriteJoinedFiles(lock sync.Locker, commit *object.Commit, files []*object.File, exp *yqlib.ExpressionNode, inputFormat string, variables map[string]string, values *yqlib.CandidateNode) ([]fileChange, error) {
+	root, joined, err := joinFiles(lock, files, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := evaluateJoined(root, exp, variables, values)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) != 1 {
+		return nil, fmt.Errorf("expression must return the joined files as one mapping keyed by path: got %d results", len(matches))
+	}
+	// a value inside the joined document is not mistaken for it, so its keys
+	// are not read as paths
+	if matches[0].Kind != yqlib.MappingNode || len(matches[0].GetPath()) != 0 {
+		return nil, fmt.Errorf("expression must return the joined files as one mapping keyed by path: got %s at %s", matches[0].Tag, yqPath(matches[0].GetPath()))
+	}
+	result := matches[0]
+
+	var changes []fileChange
+	for _, jf := range joined {
+		name := filepath.ToSlash(jf.file.Name)
+		value, found := mappingValue(result, jf.file.Name)
+		if !found {
+			continue
+		}
+		if isNullNode(value) && !jf.null {
+			changes = append(changes, fileChange{Name: name, Mode: jf.file.Mode, Before: jf.in})
+			continue
+		}
+
+		out, err := encodeJoinedFile(value, jf.format, jf.documents, jf.in)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode file %q: %w", jf.file.Name, err)
+		}
+		if bytes.Equal(out, jf.in) {
+			continue
+		}
+		changes = append(changes, fileChange{Name: name, Mode: jf.file.Mode, Before: jf.in, After: out})
+	}
+
+	for i := 0; i+1 < len(result.Content); i += 2 {
+		name := result.Content[i].Value
+		if slices.ContainsFunc(joined, func(jf joinedFile) bool { return jf.file.Name == name }) {
+			continue
+		}
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("could not create file %q: not a relative file path", name)
+		}
+		if isNullNode(result.Content[i+1]) {
+			continue
+		}
+
+		lock.Lock()
+		existsErr := checkNewFilePath(commit, name)
+		lock.Unlock()
+		if existsErr != nil {
+			return nil, fmt.Errorf("could not create file %q: %w", name, existsErr)
+		}
+
+		format, err := FileFormat(name, inputFormat)
+		if err != nil {
+			return nil, err
+		}
+		out, err := encodeJoinedFile(result.Content[i+1], format, 1, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode file %q: %w", name, err)
+		}
+		changes = append(changes, fileChange{Name: name, Mode: filemode.Regular, After: out})
+	}
+
+	return changes, nil
+}
+
+// checkNewFilePath returns an error when name or one of the directories it
+// would be created in already exists in commit as a different kind of entry.
+func checkNewFilePath(commit *object.Commit, name string) error {
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if _, err := tree.File(name); err == nil {
+		return fmt.Errorf("it exists but does not match the file pattern")
+	}
+	if _, err := tree.Tree(name); err == nil {
+		return fmt.Errorf("it is a directory")
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, err := tree.File(dir); err == nil {
+			return fmt.Errorf("%q is a file", dir)
+		}
+	}
+	return nil
+}
+
+func isNullNode(node *yqlib.CandidateNode) bool {
+	return node.Kind == yqlib.ScalarNode && node.Tag == "!!null"
+}
+
+// encodeJoinedFile encodes the value of a file in a joined document in the
+// style of in. When the file had several documents, the value is a sequence
+// of them.
+func encodeJoinedFile(value *yqlib.CandidateNode, format *yqlib.Format, documentCount int, in []byte) ([]byte, error) {
+	documents := list.New()
+	if documentCount > 1 && value.Kind == yqlib.SequenceNode {
+		for _, document := range value.Content {
+			documents.PushBack(document)
+		}
+	} else {
+		documents.PushBack(value)
+	}
+
+	style := detectFileStyle(format, in)
+	out := bytes.NewBuffer(make([]byte, 0, len(in)))
+	if err := printResults(out, newFileEncoder(format, style), documents); err != nil {
+		return nil, err
+	}
+	return style.restore(out.Bytes()), nil
+}
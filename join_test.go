@@ -0,0 +1,272 @@
+package qyt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func createJoinRepository(t *testing.T) *git.Repository {
+	t.Helper()
+
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return nil
+	}
+
+	signature := someSignature()
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return nil
+	}
+
+	files := map[string]string{
+		"services.yml":   "services: [api, web, worker]\n",
+		"deploy/api.yml": "name: api\nreplicas: 2\n",
+		"deploy/web.yml": "# web deployment\nname: web\nreplicas: 1\n",
+	}
+	for name, contents := range files {
+		createFile(t, wt.Filesystem, name, contents)
+		_, addErr := wt.Add(name)
+		if !assert.NoError(t, addErr) {
+			return nil
+		}
+	}
+	_, commitErr := wt.Commit("add services", &git.CommitOptions{Author: &signature, Committer: &signature})
+	if !assert.NoError(t, commitErr) {
+		return nil
+	}
+	return repo
+}
+
+func TestQuery_join(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+
+	var out bytes.Buffer
+	err := Query(&out, repo,
+		`.["services.yml"].services[] | select(("deploy/" + . + ".yml") as $path | $files | has($path) | not)`,
+		RefFilter{Branches: "master"},
+		FileFilter{Pattern: `.*\.yml`, Join: true},
+		nil, 1, false, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "worker\n", out.String())
+
+	var results []Result
+	for result, err := range Results(repo, `keys`, RefFilter{Branches: "master"}, FileFilter{Pattern: `.*\.yml`, Join: true}, nil, 1) {
+		if !assert.NoError(t, err) {
+			return
+		}
+		results = append(results, result)
+	}
+	if assert.Len(t, results, 1) {
+		assert.Empty(t, results[0].File)
+		assert.Equal(t, "refs/heads/master", results[0].Ref.Name().String())
+	}
+}
+
+func TestApply_join(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+
+	_, applyErr := Apply(repo,
+		`.["deploy/web.yml"].replicas = .["deploy/api.yml"].replicas`,
+		RefFilter{Branches: "master"},
+		FileFilter{Pattern: `.*\.yml`, Join: true}, "match replicas", "qyt/",
+		someSignature(), someSignature(), nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) {
+		return
+	}
+
+	ref, refErr := repo.Reference(plumbing.NewBranchReferenceName("qyt/master"), true)
+	if !assert.NoError(t, refErr) {
+		return
+	}
+	commit, commitErr := repo.CommitObject(ref.Hash())
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+	for name, expected := range map[string]string{
+		"deploy/web.yml": "# web deployment\nname: web\nreplicas: 2\n",
+		"deploy/api.yml": "name: api\nreplicas: 2\n",
+		"services.yml":   "services: [api, web, worker]\n",
+	} {
+		file, fileErr := commit.File(name)
+		if !assert.NoError(t, fileErr) {
+			return
+		}
+		contents, contentsErr := file.Contents()
+		if !assert.NoError(t, contentsErr) {
+			return
+		}
+		assert.Equal(t, expected, contents, name)
+	}
+
+	stats, statsErr := commit.Stats()
+	if !assert.NoError(t, statsErr) {
+		return
+	}
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, "deploy/web.yml", stats[0].Name)
+	}
+
+	_, err := Apply(repo, `.["services.yml"].services`,
+		RefFilter{Branches: "master"},
+		FileFilter{Pattern: `.*\.yml`, Join: true}, "bad", "bad/",
+		someSignature(), someSignature(), nil,
+		1, testing.Verbose(), false,
+	)
+	assert.ErrorContains(t, err, "mapping keyed by path")
+}
+
+func TestApply_join_create_and_delete(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+
+	var out bytes.Buffer
+	if !assert.NoError(t,
+		ApplyDryRun(&out, repo,
+			`.["deploy/web.yml"] = null | .["deploy.yml"] = {"services": .["services.yml"].services} | .["config/new/app.json"] = {"name": "app"}`,
+			RefFilter{Branches: "master"},
+			FileFilter{Pattern: `.*\.yml`, Join: true}, "restructure", "qyt/",
+			someSignature(),
+			1, testing.Verbose(), false,
+		),
+	) {
+		return
+	}
+	assert.Contains(t, out.String(), "diff --git a/deploy/web.yml b/deploy/web.yml\ndeleted file mode 100644\n")
+	assert.Contains(t, out.String(), "diff --git a/deploy.yml b/deploy.yml\nnew file mode 100644\n")
+	assert.Contains(t, out.String(), "#   master -> qyt/master (3 files)\n")
+
+	_, applyErr := Apply(repo,
+		`.["deploy/web.yml"] = null | .["deploy.yml"] = {"services": .["services.yml"].services} | .["config/new/app.json"] = {"name": "app"}`,
+		RefFilter{Branches: "master"},
+		FileFilter{Pattern: `.*\.yml`, Join: true}, "restructure", "qyt/",
+		someSignature(), someSignature(), nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) {
+		return
+	}
+
+	ref, refErr := repo.Reference(plumbing.NewBranchReferenceName("qyt/master"), true)
+	if !assert.NoError(t, refErr) {
+		return
+	}
+	commit, commitErr := repo.CommitObject(ref.Hash())
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+	tree, treeErr := commit.Tree()
+	if !assert.NoError(t, treeErr) {
+		return
+	}
+
+	var names []string
+	for _, entry := range tree.Entries {
+		names = append(names, entry.Name)
+	}
+	// git sorts directories as if their names ended with a slash
+	assert.Equal(t, []string{"config", "deploy.yml", "deploy", "services.yml"}, names)
+
+	var files []string
+	assert.NoError(t, tree.Files().ForEach(func(file *object.File) error {
+		files = append(files, file.Name)
+		return nil
+	}))
+	assert.ElementsMatch(t, []string{"config/new/app.json", "deploy.yml", "deploy/api.yml", "services.yml"}, files)
+
+	created, fileErr := commit.File("config/new/app.json")
+	if !assert.NoError(t, fileErr) {
+		return
+	}
+	contents, contentsErr := created.Contents()
+	assert.NoError(t, contentsErr)
+	assert.Equal(t, "{\n  \"name\": \"app\"\n}\n", contents)
+
+	t.Run("file exists but was not matched", func(t *testing.T) {
+		_, err := Apply(repo, `.["services.yml"] = {}`,
+			RefFilter{Branches: "master"},
+			FileFilter{Pattern: `deploy/.*\.yml`, Join: true}, "overwrite", "overwrite/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, err, "does not match the file pattern")
+	})
+
+	t.Run("path outside the repository", func(t *testing.T) {
+		_, err := Apply(repo, `.["../escape.yml"] = {}`,
+			RefFilter{Branches: "master"},
+			FileFilter{Pattern: `.*\.yml`, Join: true}, "escape", "escape/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, err, "not a relative file path")
+	})
+
+	t.Run("key names a directory", func(t *testing.T) {
+		_, err := Apply(repo, `.["deploy"] = {"a": 1}`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `services\.yml`, Join: true}, "directory", "directory/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, err, `could not create file "deploy": it is a directory`)
+	})
+
+	t.Run("key is in a file", func(t *testing.T) {
+		_, err := Apply(repo, `.["services.yml/app.yml"] = {"a": 1}`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`, Join: true}, "file", "file/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, err, `"services.yml" is a file`)
+	})
+
+	t.Run("filtered keys are unchanged", func(t *testing.T) {
+		updates, err := Apply(repo, `with_entries(select(.key == "deploy/api.yml")) | .["deploy/api.yml"].replicas = 5`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `.*\.yml`, Join: true}, "filter", "filter/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, err) || !assert.Len(t, updates, 1) {
+			return
+		}
+		commit, commitErr := repo.CommitObject(updates[0].New)
+		if !assert.NoError(t, commitErr) {
+			return
+		}
+		tree, treeErr := commit.Tree()
+		if !assert.NoError(t, treeErr) {
+			return
+		}
+		var files []string
+		assert.NoError(t, tree.Files().ForEach(func(file *object.File) error {
+			files = append(files, file.Name)
+			return nil
+		}))
+		assert.ElementsMatch(t, []string{"deploy/api.yml", "deploy/web.yml", "services.yml"}, files)
+	})
+}
@@ -0,0 +1,228 @@
+package qyt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Log evaluates the expression on every commit in the history of the matched
+// refs that changed a matched file. Each result is preceded by a comment line
+// with the commit hash, date, author, ref, and file name; newest commits come
+// first. When onlyChanges is set, a result is only written when it differs
+// from the result for the same file on the previous commit. When outputToJSON
+// is set, each result is written as a LogRecord on its own line instead.
+func Log(out io.Writer, repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, verbose, outputToJSON, onlyChanges bool) error {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	refs, err := MatchingRefs(repo, refFilter, verbose)
+	if err != nil {
+		return fmt.Errorf("failed to match refs: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	return logHistory(out, repo, yqExpression, refs, fp, fileFilter.Format, verbose, outputToJSON, onlyChanges)
+}
+
+// LogRecord is a result Log writes when outputToJSON is set.
+type LogRecord struct {
+	Commit string `json:"commit"`
+	Date   string `json:"date"`
+	Author string `json:"author"`
+	Email  string `json:"email"`
+	Ref    string `json:"ref"`
+	File   string `json:"file"`
+	// Results are the values the expression returned encoded as JSON.
+	Results []json.RawMessage `json:"results"`
+}
+
+type logEntry struct {
+	Commit   *object.Commit
+	FileName string
+	Result   []byte
+}
+
+func logHistory(out io.Writer, repo *git.Repository, exp *yqlib.ExpressionNode, refs []plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, verbose, outputToJSON, onlyChanges bool) error {
+	for _, ref := range refs {
+		if verbose {
+			_, _ = fmt.Fprintf(out, "# \twalking history of %q\n", ref.Name().Short())
+		}
+
+		entries, err := refHistory(repo, exp, ref, filePattern, inputFormat, outputToJSON)
+		if err != nil {
+			return err
+		}
+
+		if onlyChanges {
+			entries = changedLogEntries(entries)
+		}
+
+		for _, entry := range entries {
+			if outputToJSON {
+				if err := writeLogRecord(out, ref, entry); err != nil {
+					return err
+				}
+				continue
+			}
+			_, _ = fmt.Fprintf(out, "# %s %s %s <%s> %s %s\n",
+				entry.Commit.Hash, entry.Commit.Author.When.UTC().Format(time.RFC3339),
+				entry.Commit.Author.Name, entry.Commit.Author.Email,
+				ref.Name().Short(), entry.FileName)
+			if _, err := out.Write(entry.Result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeLogRecord(out io.Writer, ref plumbing.Reference, entry logEntry) error {
+	record := LogRecord{
+		Commit:  entry.Commit.Hash.String(),
+		Date:    entry.Commit.Author.When.UTC().Format(time.RFC3339),
+		Author:  entry.Commit.Author.Name,
+		Email:   entry.Commit.Author.Email,
+		Ref:     ref.Name().Short(),
+		File:    entry.FileName,
+		Results: []json.RawMessage{},
+	}
+	for line := range bytes.Lines(entry.Result) {
+		record.Results = append(record.Results, json.RawMessage(bytes.TrimSpace(line)))
+	}
+	return json.NewEncoder(out).Encode(record)
+}
+
+// refHistory evaluates the expression on the matched files of each commit
+// reachable from ref that changed them. Entries are ordered newest first.
+func refHistory(repo *git.Repository, exp *yqlib.ExpressionNode, ref plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, outputToJSON bool) ([]logEntry, error) {
+	head, err := commitForRef(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	commitIter, err := repo.Log(&git.LogOptions{From: head.Hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk history of %s: %w", ref.Name().Short(), err)
+	}
+
+	var entries []logEntry
+
+	err = commitIter.ForEach(func(commit *object.Commit) error {
+		return HandleMatchingFiles(commit, filePattern, func(file *object.File) error {
+			changed, changedErr := changedInCommit(commit, file)
+			if changedErr != nil || !changed {
+				return changedErr
+			}
+
+			rc, readerErr := file.Reader()
+			if readerErr != nil {
+				return readerErr
+			}
+			defer func() {
+				_ = rc.Close()
+			}()
+
+			scope := NewScope(ref, file)
+			scope["head"] = commit.Hash.String()
+
+			var buf bytes.Buffer
+			var applyExpressionErr error
+			if outputToJSON {
+				applyExpressionErr = writeCompactJSONResults(&buf, rc, exp, file.Name, inputFormat, scope)
+			} else {
+				applyExpressionErr = ApplyExpression(&buf, rc, exp, file.Name, inputFormat, scope, false)
+			}
+			if applyExpressionErr != nil {
+				return fmt.Errorf("could not apply yq operation to file %q on %s: %s", file.Name, commit.Hash, applyExpressionErr)
+			}
+
+			entries = append(entries, logEntry{
+				Commit:   commit,
+				FileName: file.Name,
+				Result:   buf.Bytes(),
+			})
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// writeCompactJSONResults writes each result of the expression as compact
+// JSON on its own line.
+func writeCompactJSONResults(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string) error {
+	result, err := EvaluateExpression(r, exp, filename, inputFormat, variables)
+	if err != nil {
+		return err
+	}
+	for el := result.Front(); el != nil; el = el.Next() {
+		s, err := compactJSON(el.Value.(*yqlib.CandidateNode))
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedInCommit reports whether file differs from the file with the same
+// name in every parent of commit. Like git log, a merge commit that takes a
+// file unchanged from one of its parents did not change it.
+func changedInCommit(commit *object.Commit, file *object.File) (bool, error) {
+	changed := true
+	err := commit.Parents().ForEach(func(parent *object.Commit) error {
+		parentFile, err := parent.File(file.Name)
+		if errors.Is(err, object.ErrFileNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if parentFile.Hash == file.Hash {
+			changed = false
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// changedLogEntries drops entries (ordered newest first) whose result is the
+// same as the previous result for the same file.
+func changedLogEntries(entries []logEntry) []logEntry {
+	previous := make(map[string][]byte)
+	keep := make([]bool, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		prev, seen := previous[entry.FileName]
+		keep[i] = !seen || !bytes.Equal(prev, entry.Result)
+		previous[entry.FileName] = entry.Result
+	}
+
+	filtered := entries[:0]
+	for i, entry := range entries {
+		if keep[i] {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
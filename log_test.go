@@ -0,0 +1,94 @@
+package qyt
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+
+	var hashes []string
+	for i, change := range []struct{ path, contents string }{
+		{"values.yaml", "image:\n  tag: \"1.0\"\n"},
+		{"README.md", "# readme\n"},
+		{"values.yaml", "image:\n  tag: \"1.1\"\n"},
+		{"values.yaml", "image:\n  tag: \"1.1\"\n  pullPolicy: Always\n"},
+	} {
+		createFile(t, wt.Filesystem, change.path, change.contents)
+		_, addErr := wt.Add(change.path)
+		if !assert.NoError(t, addErr) {
+			return
+		}
+		signature := someSignature()
+		signature.When = signature.When.Add(time.Duration(i) * time.Hour)
+		hash, commitErr := wt.Commit("change "+change.path, &git.CommitOptions{Author: &signature, Committer: &signature})
+		if !assert.NoError(t, commitErr) {
+			return
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	t.Run("every change to matched files", func(t *testing.T) {
+		var out bytes.Buffer
+		logErr := Log(&out, repo, ".image.tag", RefFilter{Branches: "master"}, FileFilter{Pattern: `values\.yaml`}, false, false, false)
+		if !assert.NoError(t, logErr) {
+			return
+		}
+
+		assert.Equal(t, strings.Join([]string{
+			"# " + hashes[3] + " 2021-06-03T03:29:38Z christopher <christopher@exmaple.com> master values.yaml",
+			"1.1",
+			"# " + hashes[2] + " 2021-06-03T02:29:38Z christopher <christopher@exmaple.com> master values.yaml",
+			"1.1",
+			"# " + hashes[0] + " 2021-06-03T00:29:38Z christopher <christopher@exmaple.com> master values.yaml",
+			"1.0",
+		}, "\n")+"\n", out.String())
+	})
+
+	t.Run("only changes", func(t *testing.T) {
+		var out bytes.Buffer
+		logErr := Log(&out, repo, ".image.tag", RefFilter{Branches: "master"}, FileFilter{Pattern: `values\.yaml`}, false, false, true)
+		if !assert.NoError(t, logErr) {
+			return
+		}
+
+		assert.Equal(t, strings.Join([]string{
+			"# " + hashes[2] + " 2021-06-03T02:29:38Z christopher <christopher@exmaple.com> master values.yaml",
+			"1.1",
+			"# " + hashes[0] + " 2021-06-03T00:29:38Z christopher <christopher@exmaple.com> master values.yaml",
+			"1.0",
+		}, "\n")+"\n", out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		logErr := Log(&out, repo, ".image", RefFilter{Branches: "master"}, FileFilter{Pattern: `values\.yaml`}, false, true, true)
+		if !assert.NoError(t, logErr) {
+			return
+		}
+
+		assert.Equal(t, strings.Join([]string{
+			`{"commit":"` + hashes[3] + `","date":"2021-06-03T03:29:38Z","author":"christopher","email":"christopher@exmaple.com","ref":"master","file":"values.yaml","results":[{"tag":"1.1","pullPolicy":"Always"}]}`,
+			`{"commit":"` + hashes[2] + `","date":"2021-06-03T02:29:38Z","author":"christopher","email":"christopher@exmaple.com","ref":"master","file":"values.yaml","results":[{"tag":"1.1"}]}`,
+			`{"commit":"` + hashes[0] + `","date":"2021-06-03T00:29:38Z","author":"christopher","email":"christopher@exmaple.com","ref":"master","file":"values.yaml","results":[{"tag":"1.0"}]}`,
+		}, "\n")+"\n", out.String())
+	})
+}
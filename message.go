@@ -0,0 +1,148 @@
+package qyt
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// CommitMessageData is the data the commit message template is executed
+// with.
+type CommitMessageData struct {
+	// Branch is the name of the ref the expression was applied to.
+	Branch string
+	Query  string
+
+	// NewBranch is the name of the branch the commit is added to.
+	NewBranch string
+	// BaseCommit is the hash of the commit the changes were made on.
+	BaseCommit string
+
+	Author    object.Signature
+	Committer object.Signature
+
+	// Files lists the changed files by name with the paths that changed in
+	// each and their values before and after the change.
+	Files []FileDiff
+	// FileCount is the number of changed files.
+	FileCount int
+	// Diff is a git-style unified diff of the changes.
+	Diff string
+
+	// Values are the values the expression set on $values.
+	Values map[string]string
+}
+
+// commitMessageFuncs are the functions the commit message template can use
+// in addition to the text/template builtins.
+var commitMessageFuncs = template.FuncMap{
+	"trailer":     trailer,
+	"signedOffBy": func(value any) (string, error) { return trailer("Signed-off-by", value) },
+	"tickets":     tickets,
+}
+
+// trailer formats a git trailer line like "Signed-off-by: Name <email>". The
+// value may be a string or a signature like .Author.
+func trailer(key string, value any) (string, error) {
+	var v string
+	switch value := value.(type) {
+	case string:
+		v = value
+	case object.Signature:
+		v = fmt.Sprintf("%s <%s>", value.Name, value.Email)
+	case *object.Signature:
+		v = fmt.Sprintf("%s <%s>", value.Name, value.Email)
+	default:
+		return "", fmt.Errorf("trailer %q value must be a string or signature: got %T", key, value)
+	}
+	if key == "" || strings.ContainsAny(key, ": \n") {
+		return "", fmt.Errorf("invalid trailer key %q", key)
+	}
+	return key + ": " + strings.TrimSpace(strings.ReplaceAll(v, "\n", " ")), nil
+}
+
+var ticketExp = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-[0-9]+\b|#[0-9]+\b`)
+
+// tickets returns the ticket references like "ABC-123" and "#42" in the
+// text in the order they first appear.
+func tickets(text string) []string {
+	var refs []string
+	for _, ref := range ticketExp.FindAllString(text, -1) {
+		if !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// newCommitMessageData returns the data for a commit with the changes made on
+// base.
+func newCommitMessageData(branch plumbing.Reference, newBranchName plumbing.ReferenceName, base plumbing.Hash, author, committer object.Signature, expString, inputFormat string, changes []fileChange) (CommitMessageData, error) {
+	data := CommitMessageData{
+		Branch:     newRefNames(branch.Name()).base(),
+		Query:      expString,
+		NewBranch:  newBranchName.Short(),
+		BaseCommit: base.String(),
+		Author:     author,
+		Committer:  committer,
+		Files:      make([]FileDiff, 0, len(changes)),
+		FileCount:  len(changes),
+	}
+
+	for _, change := range changes {
+		fileDiff, err := changedFileDiff(change, inputFormat)
+		if err != nil {
+			return CommitMessageData{}, err
+		}
+		data.Files = append(data.Files, fileDiff)
+	}
+	slices.SortFunc(data.Files, func(a, b FileDiff) int { return strings.Compare(a.File, b.File) })
+
+	var diff bytes.Buffer
+	if err := writeUnifiedDiff(&diff, changes); err != nil {
+		return CommitMessageData{}, err
+	}
+	data.Diff = diff.String()
+
+	return data, nil
+}
+
+// changedFileDiff compares each document of a file before and after the
+// change.
+func changedFileDiff(change fileChange, inputFormat string) (FileDiff, error) {
+	fileDiff := FileDiff{File: change.Name, Status: DiffModified, Changes: []PathChange{}}
+	switch {
+	case change.Before == nil:
+		fileDiff.Status = DiffAdded
+	case change.After == nil:
+		fileDiff.Status = DiffRemoved
+	}
+
+	var documents [2][][]*yqlib.CandidateNode
+	for i, in := range [][]byte{change.Before, change.After} {
+		if in == nil {
+			continue
+		}
+		decoded, err := decodeDocuments(bytes.NewReader(in), change.Name, inputFormat)
+		if err != nil {
+			return FileDiff{}, fmt.Errorf("could not decode file %q: %w", change.Name, err)
+		}
+		for _, document := range decoded {
+			documents[i] = append(documents[i], []*yqlib.CandidateNode{document})
+		}
+	}
+
+	changes, err := diffDocuments(documents[0], documents[1])
+	if err != nil {
+		return FileDiff{}, err
+	}
+	fileDiff.Changes = append(fileDiff.Changes, changes...)
+	return fileDiff, nil
+}
@@ -0,0 +1,85 @@
+package qyt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply_commit_message_data(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+	master, masterErr := repo.Head()
+	if !assert.NoError(t, masterErr) {
+		return
+	}
+
+	const msg = `set replicas on {{.Branch}} ({{.FileCount}} files)
+
+{{range .Files}}{{.Status}} {{.File}}{{range .Changes}}
+  {{.Path}}: {{.Old}} -> {{.New}}{{end}}
+{{end}}
+Base: {{.BaseCommit}}
+Branch: {{.NewBranch}}
+{{signedOffBy .Author}}
+{{range tickets "PLAT-12 fix #7 and PLAT-12"}}{{trailer "Refs" .}}
+{{end}}`
+
+	updates, applyErr := Apply(repo, `.replicas = 3`,
+		RefFilter{Branches: "^master$"},
+		FileFilter{Pattern: `deploy/.*\.yml`}, msg, "qyt/",
+		someSignature(), someSignature(), nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+		return
+	}
+	commit, commitErr := repo.CommitObject(updates[0].New)
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+
+	assert.Equal(t, `set replicas on master (2 files)
+
+modified deploy/api.yml
+  .replicas: 2 -> 3
+modified deploy/web.yml
+  .replicas: 1 -> 3
+
+Base: `+master.Hash().String()+`
+Branch: qyt/master
+Signed-off-by: christopher <christopher@exmaple.com>
+Refs: PLAT-12
+Refs: #7
+`, commit.Message)
+
+	t.Run("diff", func(t *testing.T) {
+		updates, applyErr := Apply(repo, `.replicas = 4`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/web\.yml`}, "{{.Diff}}", "diff/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+			return
+		}
+		commit, commitErr := repo.CommitObject(updates[0].New)
+		if !assert.NoError(t, commitErr) {
+			return
+		}
+		assert.Contains(t, commit.Message, "--- a/deploy/web.yml\n+++ b/deploy/web.yml\n")
+		assert.Contains(t, commit.Message, "-replicas: 1\n+replicas: 4\n")
+	})
+
+	t.Run("invalid trailer", func(t *testing.T) {
+		_, applyErr := Apply(repo, `.replicas = 5`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`}, `{{trailer "Signed off by" .Author}}`, "invalid/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, applyErr, `invalid trailer key "Signed off by"`)
+	})
+}
@@ -0,0 +1,85 @@
+package qyt
+
+import (
+	"io"
+	"iter"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// inOrder calls fn on each task using up to jobs goroutines and yields the
+// results in the order of tasks. Tasks that have not started when the loop
+// stops are skipped.
+func inOrder[T, R any](jobs int, tasks []T, fn func(T) R) iter.Seq[R] {
+	return func(yield func(R) bool) {
+		if jobs < 1 {
+			jobs = 1
+		}
+
+		outputs := make([]chan R, len(tasks))
+		for i := range outputs {
+			outputs[i] = make(chan R, 1)
+		}
+
+		next := make(chan int)
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			defer close(next)
+			for i := range tasks {
+				select {
+				case next <- i:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for range min(jobs, len(tasks)) {
+			go func() {
+				for i := range next {
+					outputs[i] <- fn(tasks[i])
+				}
+			}()
+		}
+
+		for _, output := range outputs {
+			if !yield(<-output) {
+				return
+			}
+		}
+	}
+}
+
+// cloneExpression copies the parsed expression tree. Some yq operators (like
+// sort) modify the tree while it is evaluated so concurrent evaluations can
+// not share it.
+func cloneExpression(exp *yqlib.ExpressionNode) *yqlib.ExpressionNode {
+	if exp == nil {
+		return nil
+	}
+	return &yqlib.ExpressionNode{
+		Operation: exp.Operation,
+		LHS:       cloneExpression(exp.LHS),
+		RHS:       cloneExpression(exp.RHS),
+	}
+}
+
+// readFile reads the contents of file while holding lock. go-git storers are
+// not safe for concurrent use.
+func readFile(lock sync.Locker, file *object.File) ([]byte, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	rc, err := file.Reader()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close()
+	}()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,75 @@
+package qyt
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuery_jobs(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	createSomeFilesWithNameKey(t, repo, "", "a", "b", "c")
+	for i := range 12 {
+		createSomeFilesWithNameKey(t, repo, fmt.Sprintf("rel-%02d", i), fmt.Sprintf("d%02d", i))
+	}
+
+	const exp = `[.name, $branch, $filename] | sort | join(" ")`
+
+	var sequential bytes.Buffer
+	if !assert.NoError(t, Query(&sequential, repo, exp, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, false)) {
+		return
+	}
+
+	var parallel bytes.Buffer
+	if !assert.NoError(t, Query(&parallel, repo, exp, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, nil, 8, false, false)) {
+		return
+	}
+
+	assert.Equal(t, sequential.String(), parallel.String())
+	assert.Contains(t, parallel.String(), "a.yml about a rel-11\n")
+
+	t.Run("collects every error", func(t *testing.T) {
+		var out bytes.Buffer
+		err := Query(&out, repo, `.name | error("failed on " + $branch)`, RefFilter{Branches: `^rel-0[12]$`}, FileFilter{Pattern: `d.*\.yml`}, nil, 4, false, false)
+		if !assert.Error(t, err) {
+			return
+		}
+		assert.Contains(t, err.Error(), "failed on rel-01")
+		assert.Contains(t, err.Error(), "failed on rel-02")
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		_, applyErr := Apply(repo, `.name |= "updated"`, RefFilter{Branches: "^rel-"}, FileFilter{Pattern: `d.*\.yml`}, "update", "updated/", someSignature(), someSignature(), nil, 4, false, false)
+		if !assert.NoError(t, applyErr) {
+			return
+		}
+		for i := range 12 {
+			ref, refErr := repo.Reference(plumbing.NewBranchReferenceName(fmt.Sprintf("updated/rel-%02d", i)), false)
+			if !assert.NoError(t, refErr) {
+				return
+			}
+			commit, commitErr := repo.CommitObject(ref.Hash())
+			if !assert.NoError(t, commitErr) {
+				return
+			}
+			file, fileErr := commit.File(fmt.Sprintf("d%02d.yml", i))
+			if !assert.NoError(t, fileErr) {
+				return
+			}
+			contents, _ := file.Contents()
+			assert.Equal(t, "---\nname: updated\n", contents)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package qyt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Push statuses of a ref in a PushReport.
+const (
+	PushPushed   = "pushed"
+	PushUpToDate = "up-to-date"
+	PushFailed   = "failed"
+)
+
+// PushReport lists the result of pushing each ref Apply updated.
+type PushReport struct {
+	Remote string      `json:"remote"`
+	Refs   []PushedRef `json:"refs"`
+}
+
+// PushedRef is the result of pushing a ref. Error is set when the remote
+// rejected the update or it could not be sent.
+type PushedRef struct {
+	Ref    string `json:"ref"`
+	Commit string `json:"commit"`
+	Forced bool   `json:"forced"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Failed reports whether any ref was not pushed.
+func (report PushReport) Failed() bool {
+	for _, ref := range report.Refs {
+		if ref.Status == PushFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteText writes a line for each ref followed by a summary.
+func (report PushReport) WriteText(w io.Writer) error {
+	failed := 0
+	for _, ref := range report.Refs {
+		line := fmt.Sprintf("%s %s %s", ref.Status, ref.Ref, ref.Commit)
+		if ref.Forced {
+			line += " (forced)"
+		}
+		if ref.Error != "" {
+			line += ": " + ref.Error
+			failed++
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# pushed %d refs to %s: %d failed\n", len(report.Refs), report.Remote, failed)
+	return err
+}
+
+// Push pushes the refs Apply updated to the remote. Each ref is pushed on its
+// own so one rejected ref does not keep the others from being pushed.
+//
+// A ref is only pushed when the remote ref does not exist or the new commit
+// is a descendant of it. With forceWithLease, a ref Apply moved is also
+// pushed when the remote ref still points at the commit its remote-tracking
+// ref (refs/remotes/<remote>/<branch>) points at, like
+// "git push --force-with-lease"; refs without a remote-tracking ref are not
+// forced. The returned error is only set when the remote does not exist.
+func Push(repo *git.Repository, remoteName string, updates []RefUpdate, forceWithLease bool) (PushReport, error) {
+	report := PushReport{
+		Remote: remoteName,
+		Refs:   []PushedRef{},
+	}
+
+	remote, err := repo.Remote(remoteName)
+	if err != nil {
+		return report, fmt.Errorf("could not find remote %q: %w", remoteName, err)
+	}
+
+	for _, update := range updates {
+		pushed := PushedRef{
+			Ref:    update.Name.String(),
+			Commit: update.New.String(),
+		}
+
+		options := &git.PushOptions{
+			RemoteName: remoteName,
+			RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", update.Name, update.Name))},
+		}
+		if forceWithLease && !update.Old.IsZero() {
+			trackingName := plumbing.NewRemoteReferenceName(remoteName, update.Name.Short())
+			tracking, trackingErr := repo.Reference(trackingName, true)
+			if trackingErr == nil {
+				// the lease is checked against the refs the remote advertises
+				// in the same session as the push
+				options.RefSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", update.Name, update.Name))}
+				options.RequireRemoteRefs = []config.RefSpec{config.RefSpec(fmt.Sprintf("%s:%s", tracking.Hash(), update.Name))}
+				pushed.Forced = true
+			}
+		}
+
+		pushErr := remote.Push(options)
+		switch {
+		case pushErr == nil:
+			pushed.Status = PushPushed
+		case errors.Is(pushErr, git.NoErrAlreadyUpToDate):
+			pushed.Status = PushUpToDate
+		default:
+			pushed.Status = PushFailed
+			pushed.Error = pushErr.Error()
+		}
+		report.Refs = append(report.Refs, pushed)
+	}
+
+	return report, nil
+}
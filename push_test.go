@@ -0,0 +1,127 @@
+package qyt
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPush(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+
+	remoteDir := t.TempDir()
+	remoteRepo, initErr := git.PlainInit(remoteDir, true)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+	_, createRemoteErr := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"file://" + remoteDir}})
+	if !assert.NoError(t, createRemoteErr) {
+		return
+	}
+
+	branchName := plumbing.NewBranchReferenceName("qyt/master")
+	apply := func(t *testing.T, exp string, allowOverridingExistingBranches bool) []RefUpdate {
+		t.Helper()
+		updates, applyErr := Apply(repo, exp,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`}, "set replicas", "qyt/",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), allowOverridingExistingBranches,
+		)
+		assert.NoError(t, applyErr)
+		return updates
+	}
+	remoteHash := func(t *testing.T) plumbing.Hash {
+		t.Helper()
+		ref, refErr := remoteRepo.Reference(branchName, true)
+		if !assert.NoError(t, refErr) {
+			return plumbing.ZeroHash
+		}
+		return ref.Hash()
+	}
+
+	updates := apply(t, `.replicas = 3`, false)
+	if !assert.Len(t, updates, 1) {
+		return
+	}
+	assert.Equal(t, branchName, updates[0].Name)
+	assert.True(t, updates[0].Old.IsZero())
+
+	t.Run("new branch", func(t *testing.T) {
+		report, err := Push(repo, "origin", updates, false)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, report.Failed())
+		assert.Equal(t, []PushedRef{{Ref: "refs/heads/qyt/master", Commit: updates[0].New.String(), Status: PushPushed}}, report.Refs)
+		assert.Equal(t, updates[0].New, remoteHash(t))
+	})
+
+	t.Run("up to date", func(t *testing.T) {
+		report, err := Push(repo, "origin", updates, false)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, report.Failed())
+		assert.Equal(t, PushUpToDate, report.Refs[0].Status)
+	})
+
+	t.Run("force with lease", func(t *testing.T) {
+		overridden := apply(t, `.replicas = 4`, true)
+		if !assert.Len(t, overridden, 1) {
+			return
+		}
+		assert.Equal(t, updates[0].New, overridden[0].Old)
+
+		report, err := Push(repo, "origin", overridden, false)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, report.Failed())
+		assert.Contains(t, report.Refs[0].Error, "non-fast-forward")
+		assert.Equal(t, updates[0].New, remoteHash(t))
+
+		report, err = Push(repo, "origin", overridden, true)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.False(t, report.Failed())
+		assert.Equal(t, PushedRef{Ref: "refs/heads/qyt/master", Commit: overridden[0].New.String(), Forced: true, Status: PushPushed}, report.Refs[0])
+		assert.Equal(t, overridden[0].New, remoteHash(t))
+	})
+
+	t.Run("lease broken", func(t *testing.T) {
+		master, masterErr := repo.Reference(plumbing.Master, true)
+		if !assert.NoError(t, masterErr) {
+			return
+		}
+		// someone else moved the branch after it was last fetched
+		if !assert.NoError(t, remoteRepo.Storer.SetReference(plumbing.NewHashReference(branchName, master.Hash()))) {
+			return
+		}
+
+		overridden := apply(t, `.replicas = 5`, true)
+		if !assert.Len(t, overridden, 1) {
+			return
+		}
+		report, err := Push(repo, "origin", overridden, true)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, report.Failed())
+		assert.Equal(t, PushFailed, report.Refs[0].Status)
+		assert.NotEmpty(t, report.Refs[0].Error)
+		assert.Equal(t, master.Hash(), remoteHash(t))
+	})
+
+	t.Run("unknown remote", func(t *testing.T) {
+		_, err := Push(repo, "upstream", updates, false)
+		assert.Error(t, err)
+	})
+}
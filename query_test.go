@@ -31,7 +31,7 @@ func TestQuery(t *testing.T) {
 	createSomeFilesWithNameKey(t, repo, "b", "bar", "baz")
 
 	var out bytes.Buffer
-	queryErr := Query(&out, repo, `{"n": .name, "b": $branch, "f": $filename}`, ".*", `.*\.yml`, false, true)
+	queryErr := Query(&out, repo, `{"n": .name, "b": $branch, "f": $filename}`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, true)
 	assert.NoError(t, queryErr)
 
 	dec := json.NewDecoder(&out)
@@ -57,6 +57,117 @@ func TestQuery(t *testing.T) {
 	}
 
 	assert.Equal(t, got, expected)
+
+	t.Run("empty branch filter", func(t *testing.T) {
+		var all bytes.Buffer
+		queryErr := Query(&all, repo, `$branch + " " + $filename`, RefFilter{}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, false)
+		assert.NoError(t, queryErr)
+		assert.Equal(t, "b bar.yml\nb baz.yml\nb foo.yml\nmaster foo.yml\n", all.String())
+	})
+}
+
+func TestQuery_remote_branches(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	assert.NoError(t, initErr)
+
+	createSomeFilesWithNameKey(t, repo, "", "foo")
+	createSomeFilesWithNameKey(t, repo, "b", "bar")
+
+	branchIter, branchIterErr := repo.Branches()
+	assert.NoError(t, branchIterErr)
+	assert.NoError(t, branchIter.ForEach(func(reference *plumbing.Reference) error {
+		remoteName := plumbing.NewRemoteReferenceName("origin", reference.Name().Short())
+		return store.SetReference(plumbing.NewHashReference(remoteName, reference.Hash()))
+	}))
+	assert.NoError(t, store.SetReference(plumbing.NewSymbolicReference("refs/remotes/origin/HEAD", "refs/remotes/origin/master")))
+
+	t.Run("remote", func(t *testing.T) {
+		var out bytes.Buffer
+		queryErr := Query(&out, repo, `$remote + " " + $branch + " " + $filename`, RefFilter{Branches: ".*", Source: RefSourceRemote}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, false)
+		assert.NoError(t, queryErr)
+
+		assert.Equal(t, "origin b bar.yml\norigin b foo.yml\norigin master foo.yml\n", out.String())
+	})
+
+	t.Run("all", func(t *testing.T) {
+		branches, matchErr := MatchingBranches("^master$", RefSourceAll, repo, false)
+		assert.NoError(t, matchErr)
+
+		var names []string
+		for _, branch := range branches {
+			names = append(names, branch.Name().String())
+		}
+		assert.Equal(t, []string{"refs/heads/master", "refs/remotes/origin/master"}, names)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, matchErr := MatchingBranches(".*", "upstream", repo, false)
+		assert.Error(t, matchErr)
+	})
+}
+
+func TestQuery_tags_and_revisions(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	assert.NoError(t, initErr)
+
+	createSomeFilesWithNameKey(t, repo, "", "foo", "bar")
+
+	head, headErr := repo.Head()
+	assert.NoError(t, headErr)
+	sig := someSignature()
+	_, tagErr := repo.CreateTag("v1.0", head.Hash(), &git.CreateTagOptions{Tagger: &sig, Message: "release"})
+	assert.NoError(t, tagErr)
+	_, tagErr = repo.CreateTag("v0.9", head.Hash(), nil)
+	assert.NoError(t, tagErr)
+
+	var out bytes.Buffer
+	queryErr := Query(&out, repo, `$branch + "|" + $tag + "|" + $rev + "|" + $filename`, RefFilter{
+		Tags:      `^v1\.`,
+		Revisions: []string{"HEAD~1"},
+	}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, false)
+	assert.NoError(t, queryErr)
+
+	assert.Equal(t, "|v1.0||bar.yml\n|v1.0||foo.yml\n||HEAD~1|foo.yml\n", out.String())
+}
+
+func TestQuery_multiple_documents(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	assert.NoError(t, initErr)
+
+	wt, wtErr := repo.Worktree()
+	assert.NoError(t, wtErr)
+	createFile(t, wt.Filesystem, "manifests.yml", "kind: Deployment\n---\nkind: Service\n")
+	_, addErr := wt.Add("manifests.yml")
+	assert.NoError(t, addErr)
+	sig := someSignature()
+	_, commitErr := wt.Commit("add manifests", &git.CommitOptions{Author: &sig, Committer: &sig})
+	assert.NoError(t, commitErr)
+
+	var out bytes.Buffer
+	queryErr := Query(&out, repo, `{"i": $documentIndex, "k": .kind}`, RefFilter{Branches: ".*"}, FileFilter{Pattern: `.*\.yml`}, nil, 1, false, true)
+	assert.NoError(t, queryErr)
+
+	type result struct {
+		I int    `json:"i"`
+		K string `json:"k"`
+	}
+	var got []result
+	dec := json.NewDecoder(&out)
+	for {
+		var r result
+		if dec.Decode(&r) != nil {
+			break
+		}
+		got = append(got, r)
+	}
+
+	assert.Equal(t, []result{{0, "Deployment"}, {1, "Service"}}, got)
 }
 
 func createSomeFilesWithNameKey(t *testing.T, repo *git.Repository, branch string, names ...string) {
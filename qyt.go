@@ -2,13 +2,18 @@ package qyt
 
 import (
 	"bytes"
+	"cmp"
 	"container/list"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/go-git/go-git/v5"
@@ -23,23 +28,57 @@ func init() {
 	yqlib.InitExpressionParser()
 }
 
-func MatchingBranches(branchPattern string, repo *git.Repository, verbose bool) ([]plumbing.Reference, error) {
+// RefSource selects which branch references MatchingBranches considers.
+type RefSource string
+
+const (
+	RefSourceLocal  RefSource = "local"
+	RefSourceRemote RefSource = "remote"
+	RefSourceAll    RefSource = "all"
+)
+
+func (source RefSource) includes(name plumbing.ReferenceName) bool {
+	switch source {
+	case RefSourceRemote:
+		return name.IsRemote()
+	case RefSourceAll:
+		return name.IsBranch() || name.IsRemote()
+	default:
+		return name.IsBranch()
+	}
+}
+
+func MatchingBranches(branchPattern string, source RefSource, repo *git.Repository, verbose bool) ([]plumbing.Reference, error) {
 	var branches []plumbing.Reference
 
+	switch source {
+	case "":
+		source = RefSourceLocal
+	case RefSourceLocal, RefSourceRemote, RefSourceAll:
+	default:
+		return nil, fmt.Errorf("unknown ref source %q: expected %q, %q, or %q", source, RefSourceLocal, RefSourceRemote, RefSourceAll)
+	}
+
 	branchExp, err := regexp.Compile(branchPattern)
 	if err != nil {
 		return nil, fmt.Errorf("could not complile branch regular expression: %w", err)
 	}
-	branchIter, err := repo.Branches()
+	refIter, err := repo.References()
 	if err != nil {
-		return nil, fmt.Errorf("faild to get branch iterator: %w", err)
+		return nil, fmt.Errorf("faild to get reference iterator: %w", err)
 	}
-	_ = branchIter.ForEach(func(reference *plumbing.Reference) error {
-		if branchExp.MatchString(reference.Name().Short()) {
+	_ = refIter.ForEach(func(reference *plumbing.Reference) error {
+		if reference.Type() != plumbing.HashReference || !source.includes(reference.Name()) {
+			return nil
+		}
+		if branchExp.MatchString(newRefNames(reference.Name()).Branch) {
 			branches = append(branches, *reference)
 		}
 		return nil
 	})
+	slices.SortFunc(branches, func(a, b plumbing.Reference) int {
+		return strings.Compare(a.Name().String(), b.Name().String())
+	})
 
 	if verbose {
 		if len(branches) == 1 {
@@ -52,99 +91,274 @@ func MatchingBranches(branchPattern string, repo *git.Repository, verbose bool)
 	return branches, nil
 }
 
-type CommitMessageData struct {
-	Branch string
-	Query  string
+// RefFilter selects the references expressions are evaluated against.
+type RefFilter struct {
+	// Branches is a regular expression matched against branch names.
+	// When empty, every branch is matched unless Tags or Revisions are set,
+	// in which case no branches are matched.
+	Branches string
+	Source   RefSource
+
+	// Tags is a regular expression matched against tag names.
+	// When empty, no tags are matched.
+	Tags string
+
+	// Revisions are resolved like git rev-parse arguments (for example
+	// "HEAD~3" or a commit hash).
+	Revisions []string
+}
+
+// FileFilter selects the files expressions are evaluated on.
+type FileFilter struct {
+	// Pattern is a regular expression matched against file paths.
+	Pattern string
+
+	// Format is the yq format files are read and written in (for example
+	// "json" or "toml"). When empty, it is detected from each file's
+	// extension.
+	Format string
+
+	// Join loads every matched file on a ref into one mapping keyed by path
+	// so the expression is evaluated once per ref and can see all of them.
+	// The mapping is also available as $files. Results of joined files have
+	// an empty File. Apply writes each file back from its key in the mapping
+	// the expression returns, deletes matched files whose value was set to
+	// null, leaves files whose key was removed unchanged, and creates files
+	// for new keys. Join is used by Query, Results, Apply, and the functions
+	// built on Results; log, blame, bisect, and diff evaluate files one at a
+	// time.
+	Join bool
+}
+
+func (filter FileFilter) compile() (*regexp.Regexp, error) {
+	if filter.Format != "" {
+		if _, err := FileFormat("", filter.Format); err != nil {
+			return nil, err
+		}
+	}
+	return regexp.Compile(filter.Pattern)
+}
+
+func MatchingRefs(repo *git.Repository, filter RefFilter, verbose bool) ([]plumbing.Reference, error) {
+	var refs []plumbing.Reference
+
+	if filter.Branches != "" || (filter.Tags == "" && len(filter.Revisions) == 0) {
+		branches, err := MatchingBranches(filter.Branches, filter.Source, repo, verbose)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, branches...)
+	}
+
+	if filter.Tags != "" {
+		tags, err := MatchingTags(filter.Tags, repo, verbose)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, tags...)
+	}
+
+	for _, rev := range filter.Revisions {
+		hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve revision %q: %w", rev, err)
+		}
+		refs = append(refs, *plumbing.NewHashReference(plumbing.ReferenceName(rev), *hash))
+	}
+
+	return refs, nil
 }
 
-func Query(out io.Writer, repo *git.Repository, yqExp, branchRegex, filePattern string, verbose, outputToJSON bool) error {
+func MatchingTags(tagPattern string, repo *git.Repository, verbose bool) ([]plumbing.Reference, error) {
+	var tags []plumbing.Reference
+
+	tagExp, err := regexp.Compile(tagPattern)
+	if err != nil {
+		return nil, fmt.Errorf("could not complile tag regular expression: %w", err)
+	}
+	tagIter, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("faild to get tag iterator: %w", err)
+	}
+	_ = tagIter.ForEach(func(reference *plumbing.Reference) error {
+		if tagExp.MatchString(reference.Name().Short()) {
+			tags = append(tags, *reference)
+		}
+		return nil
+	})
+	slices.SortFunc(tags, func(a, b plumbing.Reference) int {
+		return strings.Compare(a.Name().String(), b.Name().String())
+	})
+
+	if verbose {
+		if len(tags) == 1 {
+			fmt.Printf("# 1 tag matches regular expression %q\n", tagPattern)
+		} else {
+			fmt.Printf("# %d tags match regular expression %q\n", len(tags), tagPattern)
+		}
+	}
+
+	return tags, nil
+}
+
+// refNames holds the names a reference is exposed as in scope variables.
+// Only one of Branch, Tag, or Revision is set.
+type refNames struct {
+	Branch, Remote, Tag, Revision string
+}
+
+func newRefNames(name plumbing.ReferenceName) refNames {
+	switch {
+	case name.IsBranch():
+		return refNames{Branch: name.Short()}
+	case name.IsRemote():
+		// a remote-tracking branch name like "refs/remotes/origin/main"
+		// is split into "origin" and "main"
+		remote, branch, _ := strings.Cut(strings.TrimPrefix(name.String(), "refs/remotes/"), "/")
+		return refNames{Remote: remote, Branch: branch}
+	case name.IsTag():
+		return refNames{Tag: name.Short()}
+	default:
+		return refNames{Revision: name.String()}
+	}
+}
+
+// base returns the name branches created from the reference are derived from.
+func (names refNames) base() string {
+	switch {
+	case names.Branch != "":
+		return names.Branch
+	case names.Tag != "":
+		return names.Tag
+	default:
+		return names.Revision
+	}
+}
+
+// branchBase is like base but uses the abbreviated commit hash for
+// revisions, since a revision like "HEAD~1" is not a valid branch name.
+func branchBase(ref plumbing.Reference) string {
+	if names := newRefNames(ref.Name()); names.Revision == "" {
+		return names.base()
+	}
+	return ref.Hash().String()[:7]
+}
+
+// Query writes the result of evaluating the expression on each matched file on
+// each matched ref. Up to jobs files are evaluated concurrently; the output is
+// in the same order regardless of jobs. Evaluation errors do not stop the
+// query, they are returned together once every file has been evaluated.
+// Blobs are only evaluated once per cache (see Results).
+func Query(out io.Writer, repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, cache *ResultCache, jobs int, verbose, outputToJSON bool) error {
 	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
 	if err != nil {
 		return fmt.Errorf("failed to parse yq expression: %s\n", err)
 	}
 
-	branches, err := MatchingBranches(branchRegex, repo, verbose)
+	branches, err := MatchingRefs(repo, refFilter, verbose)
 	if err != nil {
-		return fmt.Errorf("failed to match branches: %s\n", err)
+		return fmt.Errorf("failed to match refs: %s\n", err)
 	}
 
-	fp, err := regexp.Compile(filePattern)
+	fp, err := fileFilter.compile()
 	if err != nil {
-		return fmt.Errorf("failed to parse file name pattern: %s\n", err)
+		return fmt.Errorf("failed to parse file filter: %s\n", err)
 	}
 
-	return query(out, repo, yqExpression, branches, fp, verbose, outputToJSON)
+	return query(out, repo, yqExpression, yqExp, branches, fp, fileFilter.Format, fileFilter.Join, cache, jobs, verbose, outputToJSON)
 }
 
-func query(out io.Writer, repo *git.Repository, exp *yqlib.ExpressionNode, branches []plumbing.Reference, filePattern *regexp.Regexp, verbose, outputToJSON bool) error {
-	for _, branch := range branches {
+func query(out io.Writer, repo *git.Repository, exp *yqlib.ExpressionNode, expString string, branches []plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, join bool, cache *ResultCache, jobs int, verbose, outputToJSON bool) error {
+	encoder := NewResultEncoder(out, outputToJSON)
+
+	var (
+		previous Result
+		errs     []error
+	)
+	for result, err := range results(repo, exp, expString, branches, filePattern, inputFormat, join, cache, jobs) {
 		if verbose {
-			_, _ = fmt.Fprintf(out, "# \tquerying files on %q\n", branch.Name().Short())
+			if result.Ref.Name() != previous.Ref.Name() {
+				_, _ = fmt.Fprintf(out, "# \tquerying files on %q\n", result.Ref.Name().Short())
+			}
+			if result.File != "" && (result.File != previous.File || result.Ref.Name() != previous.Ref.Name()) {
+				_, _ = fmt.Fprintf(out, "# \t\tmatched %q\n", result.File)
+			}
+			previous = result
 		}
 
-		obj, objectErr := repo.Object(plumbing.AnyObject, branch.Hash())
-		if objectErr != nil {
-			return objectErr
+		if err != nil {
+			errs = append(errs, err)
+			continue
 		}
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
 
-		resolveMatchesErr := HandleMatchingFiles(obj, filePattern, func(file *object.File) error {
-			if verbose {
-				_, _ = fmt.Fprintf(out, "# \t\tmatched %q\n", file.Name)
-			}
-
-			rc, readerErr := file.Reader()
-			if readerErr != nil {
-				return readerErr
-			}
-			defer func() {
-				_ = rc.Close()
-			}()
-
-			var buf bytes.Buffer
-
-			applyExpressionErr := ApplyExpression(&buf, rc, exp, file.Name, map[string]string{
-				"branch":   branch.Name().Short(),
-				"filename": file.Name,
-				"head":     branch.Hash().String(),
-			}, outputToJSON)
-			if applyExpressionErr != nil {
-				return fmt.Errorf("could not apply yq operation to file %q on %s: %s", file.Name, branch.Name(), applyExpressionErr)
-			}
+// Apply commits the files the expression changes on each matched ref to a
+// new branch and returns the branches it created or moved in the order of the
+// refs. A branch is only moved when it still points where it did when it was
+// read; when any branch can not be updated, the branches already moved are
+// restored and only the ones that could not be restored are returned with the
+// error. When signer is not nil, each commit is signed with it. Files are only
+// created or deleted when fileFilter.Join is set; otherwise the expression can
+// only change the matched files.
+func Apply(repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, msg, branchPrefix string, author, committer object.Signature, signer git.Signer, jobs int, verbose, allowOverridingExistingBranches bool) ([]RefUpdate, error) {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
 
-			_, err := io.Copy(out, &buf)
-			return err
-		})
+	branches, err := MatchingRefs(repo, refFilter, verbose)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match refs: %s\n", err)
+	}
 
-		if resolveMatchesErr != nil {
-			return resolveMatchesErr
-		}
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file filter: %s\n", err)
 	}
-	return nil
+
+	return apply(repo, yqExpression, branches, author, committer, signer, jobs, verbose, allowOverridingExistingBranches, fp, fileFilter.Format, fileFilter.Join, msg, branchPrefix, yqExp, nil)
 }
 
-func Apply(repo *git.Repository, yqExp, branchRegex, filePattern, msg, branchPrefix string, author object.Signature, verbose, allowOverridingExistingBranches bool) error {
+// ApplyDryRun evaluates the expression like Apply but does not write any
+// objects or branches. Instead, it writes a unified diff of the changes for
+// each branch followed by a summary of the branches that would get commits.
+func ApplyDryRun(out io.Writer, repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, msg, branchPrefix string, author object.Signature, jobs int, verbose, allowOverridingExistingBranches bool) error {
 	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
 	if err != nil {
 		return fmt.Errorf("failed to parse yq expression: %s\n", err)
 	}
 
-	branches, err := MatchingBranches(branchRegex, repo, verbose)
+	branches, err := MatchingRefs(repo, refFilter, verbose)
 	if err != nil {
-		return fmt.Errorf("failed to match branches: %s\n", err)
+		return fmt.Errorf("failed to match refs: %s\n", err)
 	}
 
-	fp, err := regexp.Compile(filePattern)
+	fp, err := fileFilter.compile()
 	if err != nil {
-		return fmt.Errorf("failed to parse file name pattern: %s\n", err)
+		return fmt.Errorf("failed to parse file filter: %s\n", err)
 	}
 
-	return apply(repo, yqExpression, branches, author, verbose, allowOverridingExistingBranches, fp, msg, branchPrefix, yqExp)
+	_, err = apply(repo, yqExpression, branches, author, author, nil, jobs, verbose, allowOverridingExistingBranches, fp, fileFilter.Format, fileFilter.Join, msg, branchPrefix, yqExp, out)
+	return err
 }
 
-func apply(repo *git.Repository, exp *yqlib.ExpressionNode, branches []plumbing.Reference, author object.Signature, verbose, allowOverridingExistingBranches bool, filePattern *regexp.Regexp, msg, branchPrefix, expString string) error {
-	commitTemplate, templateParseErr := template.New("").Parse(msg)
+// apply creates a commit on a new branch for each branch where the expression
+// changes a matched file. Up to jobs branches are evaluated concurrently and
+// nothing is written unless every branch succeeds. When dryRun is not nil,
+// the changes are written to it as a diff and the repository is not modified.
+func apply(repo *git.Repository, exp *yqlib.ExpressionNode, branches []plumbing.Reference, author, committer object.Signature, signer git.Signer, jobs int, verbose, allowOverridingExistingBranches bool, filePattern *regexp.Regexp, inputFormat string, join bool, msg, branchPrefix, expString string, dryRun io.Writer) ([]RefUpdate, error) {
+	commitTemplate, templateParseErr := template.New("").Funcs(commitMessageFuncs).Parse(msg)
+	if templateParseErr != nil {
+		return nil, fmt.Errorf("could not parse commit message template: %w", templateParseErr)
+	}
+	branchTemplate, templateParseErr := newBranchTemplate(branchPrefix)
 	if templateParseErr != nil {
-		return fmt.Errorf("could not parse commit message template: %w", templateParseErr)
+		return nil, fmt.Errorf("could not parse branch name template: %w", templateParseErr)
 	}
 
 	var (
@@ -152,181 +366,235 @@ func apply(repo *git.Repository, exp *yqlib.ExpressionNode, branches []plumbing.
 		newBlobObjects,
 		newTreeObjects []plumbing.MemoryObject
 
-		newBranches = make(map[plumbing.ReferenceName]plumbing.Hash)
+		refUpdates []RefUpdate
+
+		dryRunSummary []string
+		errs          []error
 	)
 
-	for _, branch := range branches {
-		newBranchName := plumbing.NewBranchReferenceName(branchPrefix + branch.Name().Short())
+	type branchUpdate struct {
+		branch plumbing.Reference
+		branchCommit
+		err error
+	}
 
-		commitObj, blobObjects, treeObjects, applyOnBranchErr := applyOnBranch(
-			repo, branch, newBranchName,
-			exp, commitTemplate, author,
-			expString, filePattern,
+	var lock sync.Mutex
+	updates := inOrder(jobs, branches, func(branch plumbing.Reference) (update branchUpdate) {
+		update.branch = branch
+		update.branchCommit, update.err = applyOnBranch(
+			repo, &lock, branch,
+			exp, commitTemplate, branchTemplate, author, committer, signer,
+			expString, filePattern, inputFormat, join,
 			allowOverridingExistingBranches, verbose)
+		return update
+	})
 
-		if applyOnBranchErr != nil {
-			return applyOnBranchErr
+	sourceBranches := make(map[plumbing.ReferenceName]plumbing.ReferenceName)
+	for update := range updates {
+		if update.err != nil {
+			errs = append(errs, update.err)
+			continue
 		}
 
-		if commitObj.Hash().IsZero() {
+		if len(update.changes) == 0 {
 			continue
 		}
 
-		newCommitObjects = append(newCommitObjects, commitObj)
-		newBranches[newBranchName] = commitObj.Hash()
-		newBlobObjects = append(newBlobObjects, blobObjects...)
-		newTreeObjects = append(newTreeObjects, treeObjects...)
-	}
+		if source, ok := sourceBranches[update.newBranchName]; ok {
+			errs = append(errs, fmt.Errorf("branches %q and %q would both be committed to %q", source.Short(), update.branch.Name().Short(), update.newBranchName.Short()))
+			continue
+		}
+		sourceBranches[update.newBranchName] = update.branch.Name()
 
-	for _, objList := range [][]plumbing.MemoryObject{newBlobObjects, newTreeObjects, newCommitObjects} {
-		for _, obj := range objList {
-			addObjErr := addObject(repo.Storer, obj)
-			if addObjErr != nil {
-				return addObjErr
+		if dryRun != nil {
+			_, _ = fmt.Fprintf(dryRun, "# %s from %s\n", update.newBranchName.Short(), update.branch.Name().Short())
+			if err := writeUnifiedDiff(dryRun, update.changes); err != nil {
+				return nil, err
 			}
+			dryRunSummary = append(dryRunSummary, fmt.Sprintf("#   %s -> %s (%d files)", update.branch.Name().Short(), update.newBranchName.Short(), len(update.changes)))
+			continue
 		}
+
+		newCommitObjects = append(newCommitObjects, update.commitObj)
+		refUpdates = append(refUpdates, RefUpdate{Name: update.newBranchName, Old: update.oldHash, New: update.commitObj.Hash()})
+		newBlobObjects = append(newBlobObjects, update.blobObjects...)
+		newTreeObjects = append(newTreeObjects, update.treeObjects...)
 	}
 
-	for name, hash := range newBranches {
-		if verbose {
-			fmt.Println("updating branch", name)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if dryRun != nil {
+		_, _ = fmt.Fprintf(dryRun, "# %d branches would get commits\n", len(dryRunSummary))
+		for _, line := range dryRunSummary {
+			_, _ = fmt.Fprintln(dryRun, line)
 		}
+		return nil, nil
+	}
 
-		if !allowOverridingExistingBranches {
-			_, err := repo.Storer.Reference(name)
-			if err == nil {
-				return fmt.Errorf("a branch named %q already exists", name)
+	for _, objList := range [][]plumbing.MemoryObject{newBlobObjects, newTreeObjects, newCommitObjects} {
+		for _, obj := range objList {
+			addObjErr := addObject(repo.Storer, obj)
+			if addObjErr != nil {
+				return nil, addObjErr
 			}
 		}
+	}
 
-		branchRefName := plumbing.NewHashReference(name, hash)
-
-		setRefErr := repo.Storer.SetReference(branchRefName)
-		if setRefErr != nil {
-			return setRefErr
+	if verbose {
+		for _, update := range refUpdates {
+			fmt.Println("updating branch", update.Name)
 		}
 	}
 
-	return nil
+	return updateRefs(repo.Storer, refUpdates)
 }
 
 func NewScope(branch plumbing.Reference, file *object.File) map[string]string {
+	scope := refScope(branch)
+	scope["filename"] = file.Name
+	return scope
+}
+
+// refScope returns the variables for a ref without a file, as used when the
+// files on it are joined.
+func refScope(ref plumbing.Reference) map[string]string {
+	names := newRefNames(ref.Name())
 	return map[string]string{
-		"branch":   branch.Name().Short(),
-		"filename": file.Name,
-		"head":     branch.Hash().String(),
+		"branch": names.Branch,
+		"remote": names.Remote,
+		"tag":    names.Tag,
+		"rev":    names.Revision,
+		"head":   ref.Hash().String(),
 	}
 }
 
+// branchCommit is the commit apply creates for a branch and the objects it
+// needs. It is empty when the expression does not change any files.
+type branchCommit struct {
+	newBranchName plumbing.ReferenceName
+	// oldHash is the hash newBranchName pointed to when it was read; it is
+	// zero when the branch did not exist.
+	oldHash     plumbing.Hash
+	commitObj   plumbing.MemoryObject
+	blobObjects []plumbing.MemoryObject
+	treeObjects []plumbing.MemoryObject
+	changes     []fileChange
+}
+
 func applyOnBranch(
-	repo *git.Repository, branch plumbing.Reference, newBranchName plumbing.ReferenceName,
+	repo *git.Repository, lock sync.Locker, branch plumbing.Reference,
 	exp *yqlib.ExpressionNode,
-	commitTemplate *template.Template,
-	author object.Signature,
-	expString string, filePattern *regexp.Regexp,
+	commitTemplate, branchTemplate *template.Template,
+	author, committer object.Signature, signer git.Signer,
+	expString string, filePattern *regexp.Regexp, inputFormat string, join bool,
 	allowOverridingExistingBranches, verbose bool,
-) (
-	plumbing.MemoryObject, []plumbing.MemoryObject, []plumbing.MemoryObject, error,
-) {
-	if !allowOverridingExistingBranches {
-		_, err := repo.Storer.Reference(newBranchName)
-		if err == nil {
-			return plumbing.MemoryObject{}, nil, nil,
-				fmt.Errorf("a branch named %q already exists", newBranchName.Short())
-		}
+) (branchCommit, error) {
+	lock.Lock()
+	parentCommit, matchedFiles, matchErr := matchingFilesOnBranch(repo, branch, filePattern)
+	lock.Unlock()
+	if matchErr != nil {
+		return branchCommit{}, matchErr
 	}
 
 	if verbose {
 		fmt.Printf("# \tquerying files on %q\n", branch.Name().Short())
 	}
 
-	obj, objectErr := repo.Object(plumbing.AnyObject, branch.Hash())
-	if objectErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, objectErr
-	}
-
-	parentCommit, ok := obj.(*object.Commit)
-	if !ok {
-		return plumbing.MemoryObject{}, nil, nil,
-			fmt.Errorf("%s does not point to a commit object: got type %T", branch.Name().Short(), obj)
-	}
-
-	updateCount := 0
-
 	var (
 		updatedFiles []memoryFile
+		changes      []fileChange
 		newBlobObjects,
 		newTreeObjects []plumbing.MemoryObject
 	)
 
-	resolveMatchesErr := HandleMatchingFiles(obj, filePattern, func(file *object.File) error {
-		if verbose {
-			fmt.Printf("# \t\tmatched %q\n", file.Name)
-		}
-
-		rc, readerErr := file.Reader()
-		if readerErr != nil {
-			return readerErr
-		}
-		in, readErr := io.ReadAll(rc)
-		if readErr != nil {
-			return fmt.Errorf("could not read file %q: %s", file.Name, readErr)
-		}
+	values := newPublishedValues()
+	var rewriteErr error
+	if join {
+		changes, rewriteErr = rewriteJoinedFiles(lock, parentCommit, matchedFiles, exp, inputFormat, refScope(branch), values)
+	} else {
+		changes, rewriteErr = rewriteFiles(lock, branch, matchedFiles, exp, inputFormat, values, verbose)
+	}
+	if rewriteErr != nil {
+		return branchCommit{}, rewriteErr
+	}
 
-		var out bytes.Buffer
+	if len(changes) == 0 {
+		return branchCommit{}, nil
+	}
 
-		applyExpressionErr := ApplyExpression(&out, bytes.NewReader(in), exp, file.Name, NewScope(branch, file), false)
+	publishedValues, valuesErr := publishedValueStrings(values)
+	if valuesErr != nil {
+		return branchCommit{}, fmt.Errorf("could not read values published on %q: %w", branch.Name().Short(), valuesErr)
+	}
+	newBranchName, branchNameErr := executeBranchTemplate(branchTemplate, BranchNameData{
+		Branch: branchBase(branch),
+		Query:  expString,
+		Values: publishedValues,
+	})
+	if branchNameErr != nil {
+		return branchCommit{}, fmt.Errorf("could not create branch for %q: %w", branch.Name().Short(), branchNameErr)
+	}
 
-		if applyExpressionErr != nil {
-			return applyExpressionErr
+	for _, change := range changes {
+		file := memoryFile{
+			Name:   change.Name,
+			Mode:   change.Mode,
+			Delete: change.After == nil,
 		}
-
-		if bytes.Equal(out.Bytes(), in) {
-			if verbose {
-				fmt.Printf("# \t\t\tno change\n")
+		if !file.Delete {
+			fileObj, saveObjErr := memoryBlobObject(change.After)
+			if saveObjErr != nil {
+				return branchCommit{}, saveObjErr
 			}
-			return nil
-		}
-
-		fileObj, saveObjErr := memoryBlobObject(out.Bytes())
-		if saveObjErr != nil {
-			return saveObjErr
+			file.Object = fileObj
+			newBlobObjects = append(newBlobObjects, fileObj)
 		}
+		updatedFiles = append(updatedFiles, file)
+	}
 
-		updatedFiles = append(updatedFiles, memoryFile{
-			Name:   filepath.ToSlash(file.Name),
-			Mode:   file.Mode,
-			Object: fileObj,
-		})
-		newBlobObjects = append(newBlobObjects, fileObj)
+	messageData, messageDataErr := newCommitMessageData(branch, newBranchName, parentCommit.Hash, author, committer, expString, inputFormat, changes)
+	if messageDataErr != nil {
+		return branchCommit{}, messageDataErr
+	}
+	messageData.Values = publishedValues
+	var messageBuf bytes.Buffer
+	templateExecErr := commitTemplate.Execute(&messageBuf, messageData)
+	if templateExecErr != nil {
+		return branchCommit{}, templateExecErr
+	}
 
-		updateCount++
+	lock.Lock()
+	defer lock.Unlock()
 
-		return nil
-	})
-	if resolveMatchesErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, resolveMatchesErr
+	// the branch is only moved if it is unchanged when it is written
+	var oldHash plumbing.Hash
+	if existing, err := repo.Storer.Reference(newBranchName); err == nil {
+		if !allowOverridingExistingBranches {
+			return branchCommit{}, fmt.Errorf("a branch named %q already exists", newBranchName.Short())
+		}
+		oldHash = existing.Hash()
 	}
-
-	if updateCount == 0 {
-		return plumbing.MemoryObject{}, nil, nil, nil
+	if newBranchName == branch.Name() {
+		oldHash = branch.Hash()
 	}
 
 	parentTree, treeErr := parentCommit.Tree()
 	if treeErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, treeErr
+		return branchCommit{}, treeErr
 	}
 
 	tree, updatedSubTrees, createTreeErr := createNewTreeWithFiles(parentTree, updatedFiles)
 	if createTreeErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, createTreeErr
+		return branchCommit{}, createTreeErr
 	}
 
 	for _, subTreeObj := range updatedSubTrees {
 		var subTree plumbing.MemoryObject
 		treeEncodeErr := subTreeObj.Encode(&subTree)
 		if treeEncodeErr != nil {
-			return plumbing.MemoryObject{}, nil, nil, treeEncodeErr
+			return branchCommit{}, treeEncodeErr
 		}
 		newTreeObjects = append(newTreeObjects, subTree)
 	}
@@ -334,71 +602,205 @@ func applyOnBranch(
 	var treeObj plumbing.MemoryObject
 	treeEncodeErr := tree.Encode(&treeObj)
 	if treeEncodeErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, treeEncodeErr
-	}
-
-	var messageBuf bytes.Buffer
-	templateExecErr := commitTemplate.Execute(&messageBuf, CommitMessageData{
-		Branch: branch.Name().Short(),
-		Query:  expString,
-	})
-	if templateExecErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, templateExecErr
+		return branchCommit{}, treeEncodeErr
 	}
 
 	commit := object.Commit{
 		Author:       author,
-		Committer:    author,
+		Committer:    committer,
 		Message:      messageBuf.String(),
 		TreeHash:     treeObj.Hash(),
 		ParentHashes: []plumbing.Hash{parentCommit.Hash},
 	}
+	if signer != nil {
+		signature, signErr := signCommit(signer, &commit)
+		if signErr != nil {
+			return branchCommit{}, fmt.Errorf("could not sign commit for %q: %w", branch.Name().Short(), signErr)
+		}
+		commit.PGPSignature = signature
+	}
 
 	var commitObj plumbing.MemoryObject
 
 	commitEncodeErr := commit.Encode(&commitObj)
 	if commitEncodeErr != nil {
-		return plumbing.MemoryObject{}, nil, nil, commitEncodeErr
+		return branchCommit{}, commitEncodeErr
 	}
 
 	newTreeObjects = append(newTreeObjects, treeObj)
 
-	return commitObj, newBlobObjects, newTreeObjects, nil
+	return branchCommit{
+		newBranchName: newBranchName,
+		oldHash:       oldHash,
+		commitObj:     commitObj,
+		blobObjects:   newBlobObjects,
+		treeObjects:   newTreeObjects,
+		changes:       changes,
+	}, nil
+}
+
+// rewriteFiles evaluates the expression on each file and returns the files it
+// changes.
+func rewriteFiles(lock sync.Locker, branch plumbing.Reference, files []*object.File, exp *yqlib.ExpressionNode, inputFormat string, values *yqlib.CandidateNode, verbose bool) ([]fileChange, error) {
+	var changes []fileChange
+	for _, file := range files {
+		if verbose {
+			fmt.Printf("# \t\tmatched %q\n", file.Name)
+		}
+
+		in, readErr := readFile(lock, file)
+		if readErr != nil {
+			return nil, fmt.Errorf("could not read file %q: %s", file.Name, readErr)
+		}
+
+		out := bytes.NewBuffer(make([]byte, 0, len(in)))
+
+		applyExpressionErr := rewriteFile(out, bytes.NewReader(in), exp, file.Name, inputFormat, NewScope(branch, file), values)
+
+		if applyExpressionErr != nil {
+			return nil, applyExpressionErr
+		}
+
+		if bytes.Equal(out.Bytes(), in) {
+			if verbose {
+				fmt.Printf("# \t\t\tno change\n")
+			}
+			continue
+		}
+
+		changes = append(changes, fileChange{
+			Name:   filepath.ToSlash(file.Name),
+			Mode:   file.Mode,
+			Before: in,
+			After:  out.Bytes(),
+		})
+	}
+	return changes, nil
+}
+
+// matchingFilesOnBranch returns the commit branch points to and the files in
+// it that match filePattern.
+func matchingFilesOnBranch(repo *git.Repository, branch plumbing.Reference, filePattern *regexp.Regexp) (*object.Commit, []*object.File, error) {
+	commit, err := commitForRef(repo, branch)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var files []*object.File
+	err = HandleMatchingFiles(commit, filePattern, func(file *object.File) error {
+		files = append(files, file)
+		return nil
+	})
+	return commit, files, err
+}
+
+// commitForRef returns the commit a reference points to, peeling annotated tags.
+func commitForRef(repo *git.Repository, ref plumbing.Reference) (*object.Commit, error) {
+	obj, objectErr := repo.Object(plumbing.AnyObject, ref.Hash())
+	if objectErr != nil {
+		return nil, objectErr
+	}
+
+	for {
+		tag, isTag := obj.(*object.Tag)
+		if !isTag {
+			break
+		}
+		obj, objectErr = tag.Object()
+		if objectErr != nil {
+			return nil, objectErr
+		}
+	}
+
+	commit, ok := obj.(*object.Commit)
+	if !ok {
+		return nil, fmt.Errorf("%s does not point to a commit object: got type %T", ref.Name().Short(), obj)
+	}
+	return commit, nil
 }
 
 type memoryFile struct {
 	Name   string
 	Mode   filemode.FileMode
 	Object plumbing.MemoryObject
+
+	// Delete removes the file from the tree instead of writing Object.
+	Delete bool
 }
 
+// createNewTreeWithFiles returns a copy of parent with the files written to
+// it and the new sub trees. Files that are not in parent are inserted and the
+// directories they are in are created; directories left without entries are
+// removed. A nil parent is an empty tree.
 func createNewTreeWithFiles(parent *object.Tree, files []memoryFile) (*object.Tree, []*object.Tree, error) {
-	if parent == nil {
+	if parent == nil && len(files) == 0 {
 		return nil, nil, nil
 	}
 
 	tree := new(object.Tree)
 
-	tree.Entries = make([]object.TreeEntry, len(parent.Entries))
-	copy(tree.Entries, parent.Entries)
+	if parent != nil {
+		tree.Entries = make([]object.TreeEntry, len(parent.Entries))
+		copy(tree.Entries, parent.Entries)
+	}
 
-	var updatedSubTrees []*object.Tree
+	// new files and directories get entries so they are handled like
+	// existing ones below
+	for _, file := range files {
+		name, _, inDir := strings.Cut(file.Name, "/")
+		if slices.ContainsFunc(tree.Entries, func(entry object.TreeEntry) bool { return entry.Name == name }) {
+			continue
+		}
+		switch {
+		case inDir:
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: filemode.Dir})
+		case !file.Delete:
+			tree.Entries = append(tree.Entries, object.TreeEntry{Name: name, Mode: cmp.Or(file.Mode, filemode.Regular)})
+		}
+	}
 
-	for entryIndex, entry := range tree.Entries {
-		if ent, ok := createBlobObjectForFile(entry, files); ok {
-			tree.Entries[entryIndex] = ent
+	var (
+		entries         = make([]object.TreeEntry, 0, len(tree.Entries))
+		updatedSubTrees []*object.Tree
+	)
+
+	for _, entry := range tree.Entries {
+		if file, ok := fileForTreeEntry(entry, files); ok {
+			if entry.Mode == filemode.Dir {
+				return nil, nil, fmt.Errorf("could not write file %q: it is a directory", entry.Name)
+			}
+			if !file.Delete {
+				entries = append(entries, object.TreeEntry{
+					Name: entry.Name,
+					Mode: entry.Mode,
+					Hash: file.Object.Hash(),
+				})
+			}
+			continue
+		}
+
+		if entry.Mode != filemode.Dir {
+			if slices.ContainsFunc(files, func(file memoryFile) bool { return strings.HasPrefix(file.Name, entry.Name+"/") }) {
+				return nil, nil, fmt.Errorf("could not write files in %q: it is not a directory", entry.Name)
+			}
+			entries = append(entries, entry)
 			continue
 		}
 
 		filesForEntry := filterFilesForTreeEntree(entry, files)
 
 		if len(filesForEntry) == 0 {
+			entries = append(entries, entry)
 			continue
 		}
 
-		subDir, subTreeErr := parent.Tree(entry.Name)
-		if subTreeErr != nil {
-			return nil, nil, subTreeErr
+		var subDir *object.Tree
+		if !entry.Hash.IsZero() {
+			var subTreeErr error
+			subDir, subTreeErr = parent.Tree(entry.Name)
+			if subTreeErr != nil {
+				return nil, nil, subTreeErr
+			}
 		}
 
 		subTree, subTrees, createSubTreeErr := createNewTreeWithFiles(subDir, filesForEntry)
@@ -406,15 +808,19 @@ func createNewTreeWithFiles(parent *object.Tree, files []memoryFile) (*object.Tr
 			return nil, nil, createSubTreeErr
 		}
 
-		if subTree != nil {
-			updatedSubTrees = append(updatedSubTrees, subTree)
-			tree.Entries[entryIndex].Hash = subTree.Hash
-		}
-		if len(subTrees) > 0 {
-			updatedSubTrees = append(updatedSubTrees, subTrees...)
+		// git does not store empty directories
+		if subTree == nil || len(subTree.Entries) == 0 {
+			continue
 		}
+		updatedSubTrees = append(updatedSubTrees, subTree)
+		updatedSubTrees = append(updatedSubTrees, subTrees...)
+		entry.Hash = subTree.Hash
+		entries = append(entries, entry)
 	}
 
+	slices.SortFunc(entries, compareTreeEntries)
+	tree.Entries = entries
+
 	var treeObj plumbing.MemoryObject
 	treeEncodeErr := tree.Encode(&treeObj)
 	if treeEncodeErr != nil {
@@ -428,17 +834,25 @@ func createNewTreeWithFiles(parent *object.Tree, files []memoryFile) (*object.Tr
 	return tree, updatedSubTrees, nil
 }
 
-func createBlobObjectForFile(entry object.TreeEntry, files []memoryFile) (object.TreeEntry, bool) {
+// compareTreeEntries orders entries like git does: by name, with directory
+// names compared as if they ended with a slash.
+func compareTreeEntries(a, b object.TreeEntry) int {
+	sortName := func(entry object.TreeEntry) string {
+		if entry.Mode == filemode.Dir {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	return strings.Compare(sortName(a), sortName(b))
+}
+
+func fileForTreeEntry(entry object.TreeEntry, files []memoryFile) (memoryFile, bool) {
 	for _, file := range files {
 		if file.Name == entry.Name {
-			return object.TreeEntry{
-				Name: entry.Name,
-				Mode: entry.Mode,
-				Hash: files[0].Object.Hash(),
-			}, true
+			return file, true
 		}
 	}
-	return object.TreeEntry{}, false
+	return memoryFile{}, false
 }
 
 func filterFilesForTreeEntree(entry object.TreeEntry, files []memoryFile) []memoryFile {
@@ -529,52 +943,73 @@ func memoryBlobObject(buf []byte) (_ plumbing.MemoryObject, err error) {
 	return obj, nil
 }
 
-func ApplyExpression(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filename string, variables map[string]string, outputToJSON bool) error {
-	nodes := list.New()
-
-	decoder := yqlib.NewYamlDecoder(yqlib.NewDefaultYamlPreferences())
-	if err := decoder.Init(r); err != nil {
-		return err
-	}
-	candidateNode, err := decoder.Decode()
+func ApplyExpression(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string, outputToJSON bool) error {
+	result, err := EvaluateExpression(r, exp, filename, inputFormat, variables)
 	if err != nil {
 		return err
 	}
-	candidateNode.SetFilename(filename)
-	candidateNode.EvaluateTogether = true
 
-	navigator := yqlib.NewDataTreeNavigator()
-	nodes.PushBack(candidateNode)
+	return printResults(w, newOutputEncoder(outputToJSON), result)
+}
 
-	ctx := yqlib.Context{
-		MatchingNodes: nodes,
+// newOutputEncoder returns the encoder query results are written with.
+func newOutputEncoder(outputToJSON bool) yqlib.Encoder {
+	if outputToJSON {
+		return yqlib.NewJSONEncoder(yqlib.JsonPreferences{
+			Indent:       2,
+			UnwrapScalar: true,
+		})
 	}
-	for k, v := range variables {
-		ctx.SetVariable(k, scopeVariable(v))
+	return yqlib.NewYamlEncoder(yqlib.YamlPreferences{
+		Indent:             2,
+		PrintDocSeparators: true,
+		UnwrapScalar:       true,
+	})
+}
+
+// RewriteFile evaluates the expression like ApplyExpression but writes the
+// result in the format the file was read in.
+func RewriteFile(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string) error {
+	return rewriteFile(w, r, exp, filename, inputFormat, variables, nil)
+}
+
+// rewriteFile is RewriteFile with the mapping the expression can publish
+// values on as $values.
+func rewriteFile(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string, values *yqlib.CandidateNode) error {
+	format, err := FileFormat(filename, inputFormat)
+	if err != nil {
+		return err
 	}
 
-	result, err := navigator.GetMatchingNodes(ctx, exp)
+	in, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("yq operation failed: %w", err)
+		return err
 	}
+	style := detectFileStyle(format, in)
 
-	var encoder yqlib.Encoder
-	if outputToJSON {
-		encoder = yqlib.NewJSONEncoder(yqlib.JsonPreferences{
-			Indent:       2,
-			UnwrapScalar: true,
-		})
-	} else {
-		encoder = yqlib.NewYamlEncoder(yqlib.YamlPreferences{
-			Indent:             2,
-			PrintDocSeparators: true,
-			UnwrapScalar:       true,
-		})
+	documentResults, err := evaluateDocuments(bytes.NewReader(in), exp, filename, inputFormat, variables, values)
+	if err != nil {
+		return err
+	}
+	result := list.New()
+	for _, documentResult := range documentResults {
+		result.PushBackList(documentResult)
+	}
+
+	var out bytes.Buffer
+	if err := printResults(&out, newFileEncoder(format, style), result); err != nil {
+		return err
 	}
+
+	_, err = w.Write(style.restore(out.Bytes()))
+	return err
+}
+
+func printResults(w io.Writer, encoder yqlib.Encoder, result *list.List) error {
 	printerWriter := yqlib.NewSinglePrinterWriter(w)
 	printer := yqlib.NewPrinter(encoder, printerWriter)
 
-	err = printer.PrintResults(result.MatchingNodes)
+	err := printer.PrintResults(result)
 	if err != nil {
 		return fmt.Errorf("rendering result failed: %w", err)
 	}
@@ -582,11 +1017,94 @@ func ApplyExpression(w io.Writer, r io.Reader, exp *yqlib.ExpressionNode, filena
 	return nil
 }
 
+// EvaluateExpression decodes every document read from r, evaluates the
+// expression on each of them, and returns the matched nodes in document
+// order. The index of the document being evaluated is available to the
+// expression as $documentIndex. Matched nodes keep their parent, key, and
+// document index so their location in the file can be recovered.
+func EvaluateExpression(r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string) (*list.List, error) {
+	documentResults, err := evaluateDocuments(r, exp, filename, inputFormat, variables, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := list.New()
+	for _, result := range documentResults {
+		results.PushBackList(result)
+	}
+	return results, nil
+}
+
+// evaluateDocuments is like EvaluateExpression but returns the matched nodes
+// for each document separately. When values is not nil, it is available to
+// the expression as $values.
+func evaluateDocuments(r io.Reader, exp *yqlib.ExpressionNode, filename, inputFormat string, variables map[string]string, values *yqlib.CandidateNode) ([]*list.List, error) {
+	documents, err := decodeDocuments(r, filename, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	navigator := yqlib.NewDataTreeNavigator()
+	results := make([]*list.List, 0, len(documents))
+
+	for documentIndex, document := range documents {
+		nodes := list.New()
+		nodes.PushBack(document)
+
+		ctx := yqlib.Context{
+			MatchingNodes: nodes,
+		}
+		for k, v := range variables {
+			ctx.SetVariable(k, scopeVariable(v))
+		}
+		ctx.SetVariable("documentIndex", scopeValue(strconv.Itoa(documentIndex)))
+		setValuesVariable(&ctx, values)
+
+		result, err := navigator.GetMatchingNodes(ctx, cloneExpression(exp))
+		if err != nil {
+			return nil, fmt.Errorf("yq operation failed: %w", err)
+		}
+		results = append(results, result.MatchingNodes)
+	}
+
+	return results, nil
+}
+
+func decodeDocuments(r io.Reader, filename, inputFormat string) ([]*yqlib.CandidateNode, error) {
+	format, err := FileFormat(filename, inputFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := newDecoder(format)
+	if err := decoder.Init(r); err != nil {
+		return nil, err
+	}
+
+	var documents []*yqlib.CandidateNode
+	for {
+		candidateNode, err := decoder.Decode()
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		candidateNode.SetFilename(filename)
+		candidateNode.EvaluateTogether = true
+		documents = append(documents, candidateNode)
+	}
+}
+
 func scopeVariable(value string) *list.List {
+	return scopeValue(fmt.Sprintf("%q", value))
+}
+
+func scopeValue(yamlValue string) *list.List {
 	nodes := list.New()
 
 	dec := yqlib.NewYamlDecoder(yqlib.NewDefaultYamlPreferences())
-	if err := dec.Init(strings.NewReader(fmt.Sprintf("%q", value))); err != nil {
+	if err := dec.Init(strings.NewReader(yamlValue)); err != nil {
 		panic(fmt.Sprintf("failed to decode yaml: %s", err))
 	}
 	candidateNode, err := dec.Decode()
@@ -0,0 +1,315 @@
+package qyt
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Kinds of PathChange and statuses of FileDiff.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// DiffReport lists the files where the expression returns different values on
+// the head ref than on the base ref.
+type DiffReport struct {
+	Base  string     `json:"base"`
+	Head  string     `json:"head"`
+	Files []FileDiff `json:"files"`
+}
+
+// FileDiff holds the changed paths of a file. Status is added or removed when
+// the file only exists on one of the refs.
+type FileDiff struct {
+	File    string       `json:"file"`
+	Status  string       `json:"status"`
+	Changes []PathChange `json:"changes"`
+}
+
+// PathChange is a path that was added, removed, or modified. Old and New hold
+// the values encoded as compact JSON; Old is empty for added paths and New is
+// empty for removed paths.
+type PathChange struct {
+	Document int    `json:"document"`
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+}
+
+// WriteText writes a header for each file followed by a line for each
+// changed path.
+func (report DiffReport) WriteText(w io.Writer) error {
+	for _, fileDiff := range report.Files {
+		if err := fileDiff.WriteText(w); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "# %d files differ between %s and %s\n", len(report.Files), report.Base, report.Head)
+	return err
+}
+
+// WriteText writes the file status followed by a line for each changed path.
+// Added paths are prefixed with "+", removed paths with "-", and modified
+// paths with "~".
+func (fileDiff FileDiff) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s %s\n", fileDiff.Status, fileDiff.File); err != nil {
+		return err
+	}
+	for _, change := range fileDiff.Changes {
+		location := change.Path
+		if change.Document > 0 {
+			location = fmt.Sprintf("#%d %s", change.Document, change.Path)
+		}
+		var err error
+		switch change.Kind {
+		case DiffAdded:
+			_, err = fmt.Fprintf(w, "  + %s: %s\n", location, change.New)
+		case DiffRemoved:
+			_, err = fmt.Fprintf(w, "  - %s: %s\n", location, change.Old)
+		default:
+			_, err = fmt.Fprintf(w, "  ~ %s: %s -> %s\n", location, change.Old, change.New)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DiffRefs evaluates the expression on the matched files on the base and head
+// revisions and compares the results structurally. The identity expression
+// "." compares whole files. Mapping keys are matched by name and sequence
+// items by index, so formatting, comments, and key order are not reported.
+func DiffRefs(repo *git.Repository, yqExp, base, head string, fileFilter FileFilter, cache *ResultCache, jobs int) (DiffReport, error) {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	refs, err := MatchingRefs(repo, RefFilter{Revisions: []string{base, head}}, false)
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to match refs: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return DiffReport{}, fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	return diffRefs(repo, yqExpression, yqExp, refs[0], refs[1], fp, fileFilter.Format, cache, jobs)
+}
+
+func diffRefs(repo *git.Repository, exp *yqlib.ExpressionNode, expString string, base, head plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, cache *ResultCache, jobs int) (DiffReport, error) {
+	report := DiffReport{Base: base.Name().Short(), Head: head.Name().Short(), Files: []FileDiff{}}
+	if base.Name() == head.Name() {
+		return report, nil
+	}
+
+	var (
+		fileNames []string
+		documents = [2]map[string][][]*yqlib.CandidateNode{{}, {}}
+		errs      []error
+	)
+	for result, err := range results(repo, exp, expString, []plumbing.Reference{base, head}, filePattern, inputFormat, false, cache, jobs) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		side := documents[0]
+		if result.Ref.Name() == head.Name() {
+			side = documents[1]
+		}
+		if _, seen := documents[0][result.File]; !seen {
+			if _, seen := documents[1][result.File]; !seen {
+				fileNames = append(fileNames, result.File)
+			}
+		}
+		side[result.File] = append(side[result.File], result.Nodes)
+	}
+	if len(errs) > 0 {
+		return DiffReport{}, errors.Join(errs...)
+	}
+
+	slices.Sort(fileNames)
+
+	for _, fileName := range fileNames {
+		before, inBase := documents[0][fileName]
+		after, inHead := documents[1][fileName]
+
+		fileDiff := FileDiff{File: fileName, Status: DiffModified, Changes: []PathChange{}}
+		switch {
+		case !inBase:
+			fileDiff.Status = DiffAdded
+		case !inHead:
+			fileDiff.Status = DiffRemoved
+		}
+
+		changes, err := diffDocuments(before, after)
+		if err != nil {
+			return DiffReport{}, err
+		}
+		fileDiff.Changes = append(fileDiff.Changes, changes...)
+
+		if fileDiff.Status == DiffModified && len(fileDiff.Changes) == 0 {
+			continue
+		}
+		report.Files = append(report.Files, fileDiff)
+	}
+	return report, nil
+}
+
+// diffDocuments compares the nodes matched in each document of a file on
+// both sides.
+func diffDocuments(before, after [][]*yqlib.CandidateNode) ([]PathChange, error) {
+	var changes []PathChange
+	for documentIndex := range max(len(before), len(after)) {
+		var beforeNodes, afterNodes []*yqlib.CandidateNode
+		if documentIndex < len(before) {
+			beforeNodes = before[documentIndex]
+		}
+		if documentIndex < len(after) {
+			afterNodes = after[documentIndex]
+		}
+		documentChanges, err := diffDocument(documentIndex, beforeNodes, afterNodes)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, documentChanges...)
+	}
+	return changes, nil
+}
+
+// diffDocument compares the nodes an expression matched in a document on
+// both refs. Nodes are paired by the path they were found at so results like
+// ".items[]" are compared item by item.
+func diffDocument(document int, before, after []*yqlib.CandidateNode) ([]PathChange, error) {
+	type keyedNode struct {
+		key  string
+		node *yqlib.CandidateNode
+	}
+	keyed := func(nodes []*yqlib.CandidateNode) []keyedNode {
+		seen := make(map[string]int)
+		result := make([]keyedNode, 0, len(nodes))
+		for _, node := range nodes {
+			path := yqPath(node.GetPath())
+			// computed values share a path so they are told apart by position
+			key := fmt.Sprintf("%s\x00%d", path, seen[path])
+			seen[path]++
+			result = append(result, keyedNode{key: key, node: node})
+		}
+		return result
+	}
+
+	d := nodeDiff{document: document}
+	beforeNodes, afterNodes := keyed(before), keyed(after)
+	for _, b := range beforeNodes {
+		i := slices.IndexFunc(afterNodes, func(a keyedNode) bool { return a.key == b.key })
+		if i < 0 {
+			d.removed(b.node.GetPath(), b.node)
+			continue
+		}
+		d.compare(b.node.GetPath(), b.node, afterNodes[i].node)
+	}
+	for _, a := range afterNodes {
+		if !slices.ContainsFunc(beforeNodes, func(b keyedNode) bool { return a.key == b.key }) {
+			d.added(a.node.GetPath(), a.node)
+		}
+	}
+	return d.changes, d.err
+}
+
+// nodeDiff collects the changes between two node trees and the first error
+// encoding a changed value.
+type nodeDiff struct {
+	document int
+	changes  []PathChange
+	err      error
+}
+
+func (d *nodeDiff) compare(path []any, before, after *yqlib.CandidateNode) {
+	if before.Kind == yqlib.AliasNode && before.Alias != nil {
+		before = before.Alias
+	}
+	if after.Kind == yqlib.AliasNode && after.Alias != nil {
+		after = after.Alias
+	}
+
+	switch {
+	case before.Kind == yqlib.MappingNode && after.Kind == yqlib.MappingNode:
+		for i := 0; i+1 < len(before.Content); i += 2 {
+			key := before.Content[i].Value
+			child, found := mappingValue(after, key)
+			if !found {
+				d.removed(append(slices.Clip(path), key), before.Content[i+1])
+				continue
+			}
+			d.compare(append(slices.Clip(path), key), before.Content[i+1], child)
+		}
+		for i := 0; i+1 < len(after.Content); i += 2 {
+			key := after.Content[i].Value
+			if _, found := mappingValue(before, key); !found {
+				d.added(append(slices.Clip(path), key), after.Content[i+1])
+			}
+		}
+	case before.Kind == yqlib.SequenceNode && after.Kind == yqlib.SequenceNode:
+		for i := range max(len(before.Content), len(after.Content)) {
+			switch {
+			case i >= len(after.Content):
+				d.removed(append(slices.Clip(path), i), before.Content[i])
+			case i >= len(before.Content):
+				d.added(append(slices.Clip(path), i), after.Content[i])
+			default:
+				d.compare(append(slices.Clip(path), i), before.Content[i], after.Content[i])
+			}
+		}
+	default:
+		if nodeFingerprint(before) == nodeFingerprint(after) {
+			return
+		}
+		oldValue, oldErr := compactJSON(before)
+		newValue, newErr := compactJSON(after)
+		if err := errors.Join(oldErr, newErr); err != nil {
+			d.err = cmp.Or(d.err, err)
+			return
+		}
+		d.changes = append(d.changes, PathChange{Document: d.document, Path: yqPath(path), Kind: DiffModified, Old: oldValue, New: newValue})
+	}
+}
+
+func (d *nodeDiff) added(path []any, node *yqlib.CandidateNode) {
+	value, err := compactJSON(node)
+	if err != nil {
+		d.err = cmp.Or(d.err, err)
+		return
+	}
+	d.changes = append(d.changes, PathChange{Document: d.document, Path: yqPath(path), Kind: DiffAdded, New: value})
+}
+
+func (d *nodeDiff) removed(path []any, node *yqlib.CandidateNode) {
+	value, err := compactJSON(node)
+	if err != nil {
+		d.err = cmp.Or(d.err, err)
+		return
+	}
+	d.changes = append(d.changes, PathChange{Document: d.document, Path: yqPath(path), Kind: DiffRemoved, Old: value})
+}
+
+func mappingValue(node *yqlib.CandidateNode, key string) (*yqlib.CandidateNode, bool) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,113 @@
+package qyt
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffRefs(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	sig := someSignature()
+	commitOnBranch := func(branch, from string, files map[string]string) {
+		t.Helper()
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(from)}))
+		assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Create: true, Branch: plumbing.NewBranchReferenceName(branch)}))
+		for name, contents := range files {
+			createFile(t, wt.Filesystem, name, contents)
+			_, addErr := wt.Add(name)
+			assert.NoError(t, addErr)
+		}
+		_, commitErr := wt.Commit("update "+branch, &git.CommitOptions{Author: &sig, Committer: &sig})
+		assert.NoError(t, commitErr)
+	}
+
+	createInitialCommitOnMain(t, wt)
+	commitOnBranch("base", "main", map[string]string{
+		"k8s/deployment.yaml": "spec:\n  replicas: 2\n  strategy: {type: Recreate}\n  ports: [80, 443]\n",
+		"k8s/unchanged.yaml":  "spec: {a: 1, b: 2}\n",
+		"k8s/old.yaml":        "spec: {}\n",
+	})
+	commitOnBranch("rel/2.0", "base", map[string]string{
+		// key order and layout changed but the values did not
+		"k8s/unchanged.yaml":  "# comment\nspec:\n  b: 2\n  a: 1\n",
+		"k8s/deployment.yaml": "spec:\n  replicas: 3\n  paused: true\n  ports: [80]\n",
+		"k8s/service.yaml":    "spec: {type: ClusterIP}\n",
+	})
+	_, rmErr := wt.Remove("k8s/old.yaml")
+	assert.NoError(t, rmErr)
+	_, commitErr := wt.Commit("remove old", &git.CommitOptions{Author: &sig, Committer: &sig})
+	assert.NoError(t, commitErr)
+
+	t.Run("query", func(t *testing.T) {
+		report, err := DiffRefs(repo, `.spec`, "base", "rel/2.0", FileFilter{Pattern: `k8s/.*`}, nil, 2)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.Equal(t, DiffReport{Base: "base", Head: "rel/2.0", Files: []FileDiff{
+			{File: "k8s/deployment.yaml", Status: DiffModified, Changes: []PathChange{
+				{Path: ".spec.replicas", Kind: DiffModified, Old: "2", New: "3"},
+				{Path: ".spec.strategy", Kind: DiffRemoved, Old: `{"type":"Recreate"}`},
+				{Path: ".spec.ports[1]", Kind: DiffRemoved, Old: "443"},
+				{Path: ".spec.paused", Kind: DiffAdded, New: "true"},
+			}},
+			{File: "k8s/old.yaml", Status: DiffRemoved, Changes: []PathChange{
+				{Path: ".spec", Kind: DiffRemoved, Old: "{}"},
+			}},
+			{File: "k8s/service.yaml", Status: DiffAdded, Changes: []PathChange{
+				{Path: ".spec", Kind: DiffAdded, New: `{"type":"ClusterIP"}`},
+			}},
+		}}, report)
+
+		var out bytes.Buffer
+		assert.NoError(t, report.WriteText(&out))
+		assert.Equal(t, ""+
+			"modified k8s/deployment.yaml\n"+
+			"  ~ .spec.replicas: 2 -> 3\n"+
+			"  - .spec.strategy: {\"type\":\"Recreate\"}\n"+
+			"  - .spec.ports[1]: 443\n"+
+			"  + .spec.paused: true\n"+
+			"removed k8s/old.yaml\n"+
+			"  - .spec: {}\n"+
+			"added k8s/service.yaml\n"+
+			"  + .spec: {\"type\":\"ClusterIP\"}\n"+
+			"# 3 files differ between base and rel/2.0\n", out.String())
+	})
+
+	t.Run("raw files", func(t *testing.T) {
+		report, err := DiffRefs(repo, `.`, "base", "rel/2.0", FileFilter{Pattern: `k8s/unchanged\.yaml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Empty(t, report.Files)
+
+		report, err = DiffRefs(repo, `.`, "base", "rel/2.0", FileFilter{Pattern: `k8s/deployment\.yaml`}, nil, 1)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if assert.Len(t, report.Files, 1) {
+			assert.Contains(t, report.Files[0].Changes, PathChange{Path: ".spec.replicas", Kind: DiffModified, Old: "2", New: "3"})
+		}
+	})
+
+	t.Run("unknown revision", func(t *testing.T) {
+		_, err := DiffRefs(repo, `.`, "base", "missing", FileFilter{Pattern: `k8s/.*`}, nil, 1)
+		assert.Error(t, err)
+	})
+}
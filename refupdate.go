@@ -0,0 +1,78 @@
+package qyt
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// RefUpdate is a branch Apply created or moved.
+type RefUpdate struct {
+	Name plumbing.ReferenceName
+
+	// Old is the hash the branch pointed to before Apply. It is zero when
+	// the branch was created.
+	Old plumbing.Hash
+	New plumbing.Hash
+}
+
+// updateRefs moves each branch from its old hash to its new one. A branch is
+// only moved if it still points at the old hash (or still does not exist when
+// the old hash is zero). When an update fails, the branches already moved are
+// restored in reverse order and the ones that could not be restored are
+// returned with the error.
+func updateRefs(s storer.ReferenceStorer, updates []RefUpdate) ([]RefUpdate, error) {
+	for i, update := range updates {
+		updateErr := compareAndSwapRef(s, update.Name, update.Old, update.New)
+		if updateErr == nil {
+			continue
+		}
+		updateErr = fmt.Errorf("could not update branch %q: %w", update.Name.Short(), updateErr)
+
+		var (
+			changed []RefUpdate
+			errs    = []error{updateErr}
+		)
+		for _, moved := range slices.Backward(updates[:i]) {
+			if err := compareAndSwapRef(s, moved.Name, moved.New, moved.Old); err != nil {
+				errs = append(errs, fmt.Errorf("could not restore branch %q to %s: %w", moved.Name.Short(), moved.Old, err))
+				changed = append(changed, moved)
+			}
+		}
+		slices.Reverse(changed)
+		return changed, errors.Join(errs...)
+	}
+	return updates, nil
+}
+
+// compareAndSwapRef points the branch at next if it points at previous. A
+// zero hash is a branch that does not exist, so the branch is created when
+// previous is zero and deleted when next is zero.
+func compareAndSwapRef(s storer.ReferenceStorer, name plumbing.ReferenceName, previous, next plumbing.Hash) error {
+	current, err := s.Reference(name)
+	switch {
+	case errors.Is(err, plumbing.ErrReferenceNotFound):
+		if !previous.IsZero() {
+			return fmt.Errorf("expected it to point at %s but it was deleted", previous)
+		}
+	case err != nil:
+		return err
+	case previous.IsZero():
+		return fmt.Errorf("a branch named %q already exists", name.Short())
+	case current.Hash() != previous:
+		return fmt.Errorf("expected it to point at %s but it points at %s", previous, current.Hash())
+	}
+
+	if next.IsZero() {
+		return s.RemoveReference(name)
+	}
+	ref := plumbing.NewHashReference(name, next)
+	if previous.IsZero() {
+		return s.SetReference(ref)
+	}
+	// the storage checks the old hash again while the ref is locked
+	return s.CheckAndSetReference(ref, plumbing.NewHashReference(name, previous))
+}
@@ -0,0 +1,95 @@
+package qyt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateRefs(t *testing.T) {
+	var (
+		a = plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+		b = plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+		c = plumbing.NewHash("cccccccccccccccccccccccccccccccccccccccc")
+
+		main    = plumbing.NewBranchReferenceName("main")
+		feature = plumbing.NewBranchReferenceName("qyt/main")
+		release = plumbing.NewBranchReferenceName("rel")
+	)
+
+	newStorage := func(t *testing.T) memory.ReferenceStorage {
+		t.Helper()
+		s := make(memory.ReferenceStorage)
+		assert.NoError(t, s.SetReference(plumbing.NewHashReference(main, a)))
+		assert.NoError(t, s.SetReference(plumbing.NewHashReference(release, b)))
+		return s
+	}
+	hash := func(s memory.ReferenceStorage, name plumbing.ReferenceName) plumbing.Hash {
+		ref, err := s.Reference(name)
+		if err != nil {
+			return plumbing.ZeroHash
+		}
+		return ref.Hash()
+	}
+
+	t.Run("all refs are updated", func(t *testing.T) {
+		s := newStorage(t)
+		updates := []RefUpdate{
+			{Name: main, Old: a, New: c},
+			{Name: feature, New: b},
+		}
+		changed, err := updateRefs(s, updates)
+		assert.NoError(t, err)
+		assert.Equal(t, updates, changed)
+		assert.Equal(t, c, hash(s, main))
+		assert.Equal(t, b, hash(s, feature))
+	})
+
+	t.Run("a ref changed after it was read", func(t *testing.T) {
+		s := newStorage(t)
+		changed, err := updateRefs(s, []RefUpdate{
+			{Name: main, Old: a, New: c},
+			{Name: feature, New: b},
+			{Name: release, Old: a, New: c},
+		})
+		assert.ErrorContains(t, err, `could not update branch "rel": expected it to point at `+a.String()+` but it points at `+b.String())
+		assert.Empty(t, changed)
+		assert.Equal(t, a, hash(s, main))
+		assert.Equal(t, plumbing.ZeroHash, hash(s, feature))
+		assert.Equal(t, b, hash(s, release))
+	})
+
+	t.Run("a new ref was created after it was read", func(t *testing.T) {
+		s := newStorage(t)
+		changed, err := updateRefs(s, []RefUpdate{
+			{Name: release, New: c},
+		})
+		assert.ErrorContains(t, err, `a branch named "rel" already exists`)
+		assert.Empty(t, changed)
+		assert.Equal(t, b, hash(s, release))
+	})
+
+	t.Run("a moved ref can not be restored", func(t *testing.T) {
+		s := failingRemoveStorage{ReferenceStorage: newStorage(t)}
+		changed, err := updateRefs(s, []RefUpdate{
+			{Name: main, Old: a, New: c},
+			{Name: feature, New: b},
+			{Name: release, Old: a, New: c},
+		})
+		assert.ErrorContains(t, err, `could not restore branch "qyt/main"`)
+		assert.Equal(t, []RefUpdate{{Name: feature, New: b}}, changed)
+		assert.Equal(t, a, hash(s.ReferenceStorage, main))
+		assert.Equal(t, b, hash(s.ReferenceStorage, feature))
+	})
+}
+
+type failingRemoveStorage struct {
+	memory.ReferenceStorage
+}
+
+func (failingRemoveStorage) RemoveReference(plumbing.ReferenceName) error {
+	return errors.New("remove failed")
+}
@@ -0,0 +1,257 @@
+package qyt
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"iter"
+	"regexp"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// Result holds the nodes an expression matched in one document of a file.
+type Result struct {
+	// Ref is the branch, tag, or revision the file was read from.
+	Ref plumbing.Reference
+
+	// Commit is the commit Ref points to. It differs from Ref.Hash() for
+	// annotated tags.
+	Commit plumbing.Hash
+
+	File     string
+	Blob     plumbing.Hash
+	Document int
+
+	Nodes []*yqlib.CandidateNode
+}
+
+// Branch returns the name of the branch the result was read from or an empty
+// string when the result was read from a tag or revision.
+func (result Result) Branch() string {
+	return newRefNames(result.Ref.Name()).Branch
+}
+
+// Results evaluates the expression on each document of the matched files on
+// the matched refs. Refs are visited in the order MatchingRefs returns them
+// and files in tree order. A result is yielded for every document, even when
+// the expression matched no nodes in it.
+//
+// Up to jobs files are evaluated concurrently; results are yielded in the
+// same order regardless of jobs. Files with a blob that is already in cache
+// are not evaluated again; when cache is nil, a cache is only used for this
+// call. Errors evaluating a file are yielded with a
+// Result that has the Ref and File set; iteration continues with the next
+// file unless the loop stops.
+func Results(repo *git.Repository, yqExp string, refFilter RefFilter, fileFilter FileFilter, cache *ResultCache, jobs int) iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+		if err != nil {
+			yield(Result{}, fmt.Errorf("failed to parse yq expression: %s\n", err))
+			return
+		}
+
+		refs, err := MatchingRefs(repo, refFilter, false)
+		if err != nil {
+			yield(Result{}, fmt.Errorf("failed to match refs: %s\n", err))
+			return
+		}
+
+		fp, err := fileFilter.compile()
+		if err != nil {
+			yield(Result{}, fmt.Errorf("failed to parse file filter: %s\n", err))
+			return
+		}
+
+		results(repo, yqExpression, yqExp, refs, fp, fileFilter.Format, fileFilter.Join, cache, jobs)(yield)
+	}
+}
+
+func results(repo *git.Repository, exp *yqlib.ExpressionNode, expString string, refs []plumbing.Reference, filePattern *regexp.Regexp, inputFormat string, join bool, cache *ResultCache, jobs int) iter.Seq2[Result, error] {
+	return func(yield func(Result, error) bool) {
+		var tasks []fileTask
+		for _, ref := range refs {
+			commit, err := commitForRef(repo, ref)
+			if err != nil {
+				tasks = append(tasks, fileTask{ref: ref, err: err})
+				continue
+			}
+			joinTask := fileTask{ref: ref, commit: commit.Hash, join: join}
+			err = HandleMatchingFiles(commit, filePattern, func(file *object.File) error {
+				if join {
+					joinTask.files = append(joinTask.files, file)
+					return nil
+				}
+				tasks = append(tasks, fileTask{ref: ref, commit: commit.Hash, file: file})
+				return nil
+			})
+			switch {
+			case err != nil:
+				tasks = append(tasks, fileTask{ref: ref, commit: commit.Hash, err: err})
+			case join:
+				tasks = append(tasks, joinTask)
+			}
+		}
+
+		if cache == nil {
+			cache = NewResultCache()
+		}
+		deps := dependenciesOf(exp)
+
+		var lock sync.Mutex
+		evaluated := inOrder(jobs, tasks, func(task fileTask) fileTask {
+			if task.err != nil {
+				return task
+			}
+			if task.join {
+				return evaluateJoinTask(&lock, task, exp, inputFormat)
+			}
+
+			scope := NewScope(task.ref, task.file)
+			key := deps.key(expString, inputFormat, task.file, scope)
+			var (
+				documents [][]*yqlib.CandidateNode
+				cached    bool
+			)
+			if key != "" {
+				documents, cached = cache.get(key)
+			}
+			if !cached {
+				in, err := readFile(&lock, task.file)
+				if err != nil {
+					task.err = err
+					return task
+				}
+				documents, err = evaluateFile(in, exp, task.file.Name, inputFormat, scope)
+				if err != nil {
+					task.err = fmt.Errorf("could not apply yq operation to file %q on %s: %s", task.file.Name, task.ref.Name(), err)
+					return task
+				}
+				if key != "" {
+					cache.put(key, documents)
+				}
+			}
+
+			task.results = make([]Result, 0, len(documents))
+			for documentIndex, nodes := range documents {
+				task.results = append(task.results, Result{
+					Ref:      task.ref,
+					Commit:   task.commit,
+					File:     task.file.Name,
+					Blob:     task.file.Hash,
+					Document: documentIndex,
+					Nodes:    nodes,
+				})
+			}
+			return task
+		})
+
+		for task := range evaluated {
+			if task.err != nil {
+				result := Result{Ref: task.ref, Commit: task.commit}
+				if task.file != nil {
+					result.File, result.Blob = task.file.Name, task.file.Hash
+				}
+				if !yield(result, task.err) {
+					return
+				}
+				continue
+			}
+			for _, result := range task.results {
+				if !yield(result, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// fileTask is a matched file on a ref. A task with an error and without a
+// file records a ref that could not be read. A join task holds every matched
+// file on the ref instead.
+type fileTask struct {
+	ref    plumbing.Reference
+	commit plumbing.Hash
+	file   *object.File
+
+	join  bool
+	files []*object.File
+
+	results []Result
+	err     error
+}
+
+// evaluateJoinTask evaluates the expression once on all the files of a join
+// task. Joined results are not cached.
+func evaluateJoinTask(lock sync.Locker, task fileTask, exp *yqlib.ExpressionNode, inputFormat string) fileTask {
+	root, _, err := joinFiles(lock, task.files, inputFormat)
+	if err != nil {
+		task.err = err
+		return task
+	}
+	nodes, err := evaluateJoined(root, exp, refScope(task.ref), nil)
+	if err != nil {
+		task.err = fmt.Errorf("could not apply yq operation to the files on %s: %s", task.ref.Name(), err)
+		return task
+	}
+	task.results = []Result{{
+		Ref:    task.ref,
+		Commit: task.commit,
+		Nodes:  nodes,
+	}}
+	return task
+}
+
+// evaluateFile returns the nodes the expression matches in each document.
+func evaluateFile(in []byte, exp *yqlib.ExpressionNode, filename, inputFormat string, scope map[string]string) ([][]*yqlib.CandidateNode, error) {
+	documentResults, err := evaluateDocuments(bytes.NewReader(in), exp, filename, inputFormat, scope, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([][]*yqlib.CandidateNode, 0, len(documentResults))
+	for _, matches := range documentResults {
+		var nodes []*yqlib.CandidateNode
+		for el := matches.Front(); el != nil; el = el.Next() {
+			nodes = append(nodes, el.Value.(*yqlib.CandidateNode))
+		}
+		documents = append(documents, nodes)
+	}
+	return documents, nil
+}
+
+// ResultEncoder writes results as YAML or JSON like qyt query. Documents of
+// the same file are separated the way yq separates them.
+type ResultEncoder struct {
+	out          io.Writer
+	outputToJSON bool
+
+	printer yqlib.Printer
+	ref     plumbing.ReferenceName
+	file    string
+}
+
+func NewResultEncoder(out io.Writer, outputToJSON bool) *ResultEncoder {
+	return &ResultEncoder{out: out, outputToJSON: outputToJSON}
+}
+
+func (enc *ResultEncoder) Encode(result Result) error {
+	if enc.printer == nil || enc.ref != result.Ref.Name() || enc.file != result.File {
+		enc.printer = yqlib.NewPrinter(newOutputEncoder(enc.outputToJSON), yqlib.NewSinglePrinterWriter(enc.out))
+		enc.ref, enc.file = result.Ref.Name(), result.File
+	}
+
+	nodes := list.New()
+	for _, node := range result.Nodes {
+		nodes.PushBack(node)
+	}
+	if err := enc.printer.PrintResults(nodes); err != nil {
+		return fmt.Errorf("rendering result failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package qyt
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResults(t *testing.T) {
+	fs := memfs.New()
+	store := memory.NewStorage()
+	repo, initErr := git.Init(store, fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	createFile(t, wt.Filesystem, "manifests.yml", "kind: Deployment\n---\nkind: Service\n")
+	createFile(t, wt.Filesystem, "broken.yml", "kind: [\n")
+	_, addErr := wt.Add(".")
+	if !assert.NoError(t, addErr) {
+		return
+	}
+	sig := someSignature()
+	commitHash, commitErr := wt.Commit("add manifests", &git.CommitOptions{Author: &sig, Committer: &sig})
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+	tag, tagErr := repo.CreateTag("v1", commitHash, &git.CreateTagOptions{Tagger: &sig, Message: "release"})
+	if !assert.NoError(t, tagErr) {
+		return
+	}
+
+	commit, getCommitErr := repo.CommitObject(commitHash)
+	if !assert.NoError(t, getCommitErr) {
+		return
+	}
+	file, fileErr := commit.File("manifests.yml")
+	if !assert.NoError(t, fileErr) {
+		return
+	}
+
+	var (
+		got    []Result
+		errors []error
+	)
+	for result, err := range Results(repo, `.kind`, RefFilter{Branches: "master", Tags: "v1"}, FileFilter{Pattern: `.*\.yml`}, nil, 1) {
+		if err != nil {
+			errors = append(errors, err)
+			assert.Equal(t, "broken.yml", result.File)
+			continue
+		}
+		got = append(got, result)
+	}
+
+	assert.Len(t, errors, 2)
+	if !assert.Len(t, got, 4) {
+		return
+	}
+
+	assert.Equal(t, "master", got[0].Branch())
+	assert.Equal(t, plumbing.NewBranchReferenceName("master"), got[0].Ref.Name())
+	assert.Equal(t, commitHash, got[0].Commit)
+	assert.Equal(t, "manifests.yml", got[0].File)
+	assert.Equal(t, file.Hash, got[0].Blob)
+	assert.Equal(t, 0, got[0].Document)
+	if assert.Len(t, got[0].Nodes, 1) {
+		assert.Equal(t, "Deployment", got[0].Nodes[0].Value)
+	}
+	assert.Equal(t, 1, got[1].Document)
+	if assert.Len(t, got[1].Nodes, 1) {
+		assert.Equal(t, "Service", got[1].Nodes[0].Value)
+	}
+
+	assert.Equal(t, "", got[2].Branch())
+	assert.Equal(t, tag.Hash(), got[2].Ref.Hash())
+	assert.Equal(t, commitHash, got[2].Commit)
+
+	t.Run("stop", func(t *testing.T) {
+		count := 0
+		for range Results(repo, `.kind`, RefFilter{Branches: "master"}, FileFilter{Pattern: `manifests\.yml`}, nil, 1) {
+			count++
+			break
+		}
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("parse error", func(t *testing.T) {
+		for _, err := range Results(repo, `.kind[`, RefFilter{Branches: "master"}, FileFilter{Pattern: `.*`}, nil, 1) {
+			assert.Error(t, err)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package qyt
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// CommitSigner returns a signer for the key git signs commits with. The key
+// is read from user.signingkey and gpg.format from the local, global, and
+// system git config.
+//
+// With the "openpgp" format (the default), user.signingkey is a path to an
+// unencrypted private key, or a key id that is passed to gpg.program (gpg by
+// default) like git does. With the "ssh" format, it is a path to a private
+// key, or to a public key (or "key::" followed by one) whose private key is in
+// the ssh-agent at SSH_AUTH_SOCK.
+//
+// It returns nil when sign is false and commit.gpgsign is not true.
+func CommitSigner(repo *git.Repository, sign bool) (git.Signer, error) {
+	configs, err := gitConfigs(repo)
+	if err != nil {
+		return nil, err
+	}
+	if !sign && !strings.EqualFold(gitConfigOption(configs, "commit", "gpgsign"), "true") {
+		return nil, nil
+	}
+
+	key := gitConfigOption(configs, "user", "signingkey")
+	if key == "" {
+		return nil, errors.New("could not sign commits: user.signingkey is not set in git config")
+	}
+	if rest, ok := strings.CutPrefix(key, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		key = filepath.Join(home, rest)
+	}
+
+	switch format := gitConfigOption(configs, "gpg", "format"); format {
+	case "", "openpgp":
+		return newOpenPGPSigner(key, gitConfigOption(configs, "gpg", "program"))
+	case "ssh":
+		return newSSHSigner(key)
+	default:
+		return nil, fmt.Errorf("could not sign commits: gpg.format %q is not supported", format)
+	}
+}
+
+// signCommit returns the signature of the commit without a signature.
+func signCommit(signer git.Signer, commit *object.Commit) (string, error) {
+	var unsigned plumbing.MemoryObject
+	if err := commit.EncodeWithoutSignature(&unsigned); err != nil {
+		return "", err
+	}
+	r, err := unsigned.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = r.Close() }()
+	signature, err := signer.Sign(r)
+	if err != nil {
+		return "", err
+	}
+	return string(signature), nil
+}
+
+// openPGPSigner signs commits with a private key.
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func newOpenPGPSigner(key, program string) (git.Signer, error) {
+	keyFile, err := os.ReadFile(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return gpgProgramSigner{program: cmp.Or(program, "gpg"), key: key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing key: %w", err)
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyFile))
+	if err != nil {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(keyFile))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key %s: %w", key, err)
+	}
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			return nil, fmt.Errorf("could not use signing key %s: encrypted keys are not supported", key)
+		}
+		return openPGPSigner{entity: entity}, nil
+	}
+	return nil, fmt.Errorf("signing key %s does not contain a private key", key)
+}
+
+func (s openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+// gpgProgramSigner signs commits by running gpg with a key id.
+type gpgProgramSigner struct {
+	program, key string
+}
+
+func (s gpgProgramSigner) Sign(message io.Reader) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(s.program, "--status-fd=2", "-bsau", s.key)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = message, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed to sign the commit: %w: %s", s.program, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// sshSigner signs commits with an SSH key in the format of "ssh-keygen -Y
+// sign -n git".
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func newSSHSigner(key string) (git.Signer, error) {
+	var publicKeyLine []byte
+	if literal, ok := strings.CutPrefix(key, "key::"); ok {
+		publicKeyLine = []byte(literal)
+	} else {
+		keyFile, err := os.ReadFile(key)
+		if err != nil {
+			return nil, fmt.Errorf("could not read signing key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyFile)
+		if err == nil {
+			return sshSigner{signer: signer}, nil
+		}
+		var missingPassphrase *ssh.PassphraseMissingError
+		if errors.As(err, &missingPassphrase) {
+			return nil, fmt.Errorf("could not use signing key %s: encrypted keys are only supported through ssh-agent", key)
+		}
+		publicKeyLine = keyFile
+	}
+
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(publicKeyLine)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse signing key %s: %w", key, err)
+	}
+	return newSSHAgentSigner(publicKey)
+}
+
+// newSSHAgentSigner returns a signer for the key in the ssh-agent. The
+// agent is connected to each time a commit is signed.
+func newSSHAgentSigner(publicKey ssh.PublicKey) (git.Signer, error) {
+	if err := withSSHAgentKey(publicKey, func(ssh.Signer) error { return nil }); err != nil {
+		return nil, err
+	}
+	return sshAgentSigner{publicKey: publicKey}, nil
+}
+
+// sshAgentSigner is an sshSigner for a key held by the ssh-agent.
+type sshAgentSigner struct {
+	publicKey ssh.PublicKey
+}
+
+func (s sshAgentSigner) Sign(message io.Reader) ([]byte, error) {
+	var signature []byte
+	err := withSSHAgentKey(s.publicKey, func(signer ssh.Signer) error {
+		var err error
+		signature, err = sshSigner{signer: signer}.Sign(message)
+		return err
+	})
+	return signature, err
+}
+
+// withSSHAgentKey connects to the ssh-agent and calls fn with the signer for
+// publicKey. The connection is closed when fn returns.
+func withSSHAgentKey(publicKey ssh.PublicKey, fn func(ssh.Signer) error) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("could not find the private key for the signing key: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return fmt.Errorf("could not connect to ssh-agent: %w", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return fmt.Errorf("could not list ssh-agent keys: %w", err)
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+			return fn(signer)
+		}
+	}
+	return fmt.Errorf("ssh-agent does not have the signing key %s", ssh.FingerprintSHA256(publicKey))
+}
+
+const (
+	sshSignatureNamespace     = "git"
+	sshSignatureHashAlgorithm = "sha512"
+)
+
+func (s sshSigner) Sign(message io.Reader) ([]byte, error) {
+	hash := sha512.New()
+	if _, err := io.Copy(hash, message); err != nil {
+		return nil, err
+	}
+	signed := sshSignedData(sshSignatureNamespace, hash.Sum(nil))
+
+	var (
+		signature *ssh.Signature
+		err       error
+	)
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-keygen does not accept SHA-1 RSA signatures
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{
+		Version:       1,
+		PublicKey:     s.signer.PublicKey().Marshal(),
+		Namespace:     sshSignatureNamespace,
+		HashAlgorithm: sshSignatureHashAlgorithm,
+		Signature:     ssh.Marshal(signature),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+	return []byte(armored.String()), nil
+}
+
+// sshSignedData returns the data an SSH signature signs for a message hash.
+func sshSignedData(namespace string, hash []byte) []byte {
+	return append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{
+		Namespace:     namespace,
+		HashAlgorithm: sshSignatureHashAlgorithm,
+		Hash:          hash,
+	})...)
+}
@@ -0,0 +1,293 @@
+package qyt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestCommitSigner(t *testing.T) {
+	// keep the git config of the machine running the tests out of the way
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	setConfig := func(t *testing.T, repo *git.Repository, options map[[2]string]string) {
+		t.Helper()
+		c, err := repo.Config()
+		if !assert.NoError(t, err) {
+			return
+		}
+		for key, value := range options {
+			c.Raw.SetOption(key[0], "", key[1], value)
+		}
+		assert.NoError(t, repo.SetConfig(c))
+	}
+	applyAndLoadCommit := func(t *testing.T, repo *git.Repository, signer git.Signer) *object.Commit {
+		t.Helper()
+		updates, applyErr := Apply(repo, `.replicas = 3`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`}, "set replicas", "signed/",
+			someSignature(), someSignature(), signer,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+			return nil
+		}
+		commit, commitErr := repo.CommitObject(updates[0].New)
+		if !assert.NoError(t, commitErr) {
+			return nil
+		}
+		return commit
+	}
+
+	t.Run("not configured", func(t *testing.T) {
+		repo := createJoinRepository(t)
+		if repo == nil {
+			return
+		}
+		signer, err := CommitSigner(repo, false)
+		assert.NoError(t, err)
+		assert.Nil(t, signer)
+
+		_, err = CommitSigner(repo, true)
+		assert.ErrorContains(t, err, "user.signingkey is not set")
+
+		setConfig(t, repo, map[[2]string]string{{"user", "signingkey"}: "key", {"gpg", "format"}: "x509"})
+		_, err = CommitSigner(repo, true)
+		assert.ErrorContains(t, err, `gpg.format "x509" is not supported`)
+	})
+
+	t.Run("openpgp", func(t *testing.T) {
+		repo := createJoinRepository(t)
+		if repo == nil {
+			return
+		}
+
+		entity, err := openpgp.NewEntity("qyt", "", "qyt@example.com", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var privateKey, publicKey bytes.Buffer
+		w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, entity.SerializePrivate(w, nil))
+		assert.NoError(t, w.Close())
+		w, err = armor.Encode(&publicKey, openpgp.PublicKeyType, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, entity.Serialize(w))
+		assert.NoError(t, w.Close())
+
+		keyPath := filepath.Join(t.TempDir(), "key.asc")
+		if !assert.NoError(t, os.WriteFile(keyPath, privateKey.Bytes(), 0o600)) {
+			return
+		}
+		setConfig(t, repo, map[[2]string]string{{"user", "signingkey"}: keyPath, {"commit", "gpgsign"}: "true"})
+
+		signer, err := CommitSigner(repo, false)
+		if !assert.NoError(t, err) || !assert.NotNil(t, signer) {
+			return
+		}
+		commit := applyAndLoadCommit(t, repo, signer)
+		if commit == nil {
+			return
+		}
+		assert.True(t, strings.HasPrefix(commit.PGPSignature, "-----BEGIN PGP SIGNATURE-----"))
+		_, verifyErr := commit.Verify(publicKey.String())
+		assert.NoError(t, verifyErr)
+	})
+
+	t.Run("ssh", func(t *testing.T) {
+		repo := createJoinRepository(t)
+		if repo == nil {
+			return
+		}
+
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if !assert.NoError(t, err) {
+			return
+		}
+		block, err := ssh.MarshalPrivateKey(privateKey, "")
+		if !assert.NoError(t, err) {
+			return
+		}
+		keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+		if !assert.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600)) {
+			return
+		}
+		setConfig(t, repo, map[[2]string]string{{"user", "signingkey"}: keyPath, {"gpg", "format"}: "ssh"})
+
+		signer, err := CommitSigner(repo, true)
+		if !assert.NoError(t, err) || !assert.NotNil(t, signer) {
+			return
+		}
+		commit := applyAndLoadCommit(t, repo, signer)
+		if commit == nil {
+			return
+		}
+
+		sshPublicKey, err := ssh.NewPublicKey(publicKey)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var unsigned plumbing.MemoryObject
+		if !assert.NoError(t, commit.EncodeWithoutSignature(&unsigned)) {
+			return
+		}
+		r, err := unsigned.Reader()
+		if !assert.NoError(t, err) {
+			return
+		}
+		message, err := io.ReadAll(r)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assertSSHSignature(t, sshPublicKey, message, commit.PGPSignature)
+	})
+
+	t.Run("ssh agent", func(t *testing.T) {
+		repo := createJoinRepository(t)
+		if repo == nil {
+			return
+		}
+
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if !assert.NoError(t, err) {
+			return
+		}
+		keyring := agent.NewKeyring()
+		if !assert.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: privateKey})) {
+			return
+		}
+		// unix socket paths are limited to about 100 bytes
+		dir, err := os.MkdirTemp("", "qyt-agent")
+		if !assert.NoError(t, err) {
+			return
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		socket := filepath.Join(dir, "agent.sock")
+		listener, err := net.Listen("unix", socket)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var connections sync.WaitGroup
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				connections.Go(func() {
+					_ = agent.ServeAgent(keyring, conn)
+				})
+			}
+		}()
+		t.Setenv("SSH_AUTH_SOCK", socket)
+
+		signers, err := keyring.Signers()
+		if !assert.NoError(t, err) || !assert.Len(t, signers, 1) {
+			return
+		}
+		sshPublicKey := signers[0].PublicKey()
+		setConfig(t, repo, map[[2]string]string{
+			{"user", "signingkey"}: "key::" + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))),
+			{"gpg", "format"}:      "ssh",
+		})
+
+		signer, err := CommitSigner(repo, true)
+		if !assert.NoError(t, err) || !assert.NotNil(t, signer) {
+			return
+		}
+		commit := applyAndLoadCommit(t, repo, signer)
+		if commit == nil {
+			return
+		}
+		assert.True(t, strings.HasPrefix(commit.PGPSignature, "-----BEGIN SSH SIGNATURE-----"))
+
+		// the agent only stops serving a connection when the client closes it
+		assert.NoError(t, listener.Close())
+		closed := make(chan struct{})
+		go func() {
+			connections.Wait()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Error("ssh-agent connections were not closed")
+		}
+	})
+}
+
+// assertSSHSignature checks an armored signature like "ssh-keygen -Y verify
+// -n git" would.
+func assertSSHSignature(t *testing.T, publicKey ssh.PublicKey, message []byte, armored string) {
+	t.Helper()
+
+	body, found := strings.CutPrefix(armored, "-----BEGIN SSH SIGNATURE-----\n")
+	if !assert.True(t, found, armored) {
+		return
+	}
+	body, found = strings.CutSuffix(body, "-----END SSH SIGNATURE-----\n")
+	if !assert.True(t, found, armored) {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSpace(body), "\n") {
+		assert.LessOrEqual(t, len(line), 70)
+	}
+	blob, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(body, "\n", ""))
+	if !assert.NoError(t, err) {
+		return
+	}
+	blob, found = bytes.CutPrefix(blob, []byte("SSHSIG"))
+	if !assert.True(t, found) {
+		return
+	}
+
+	var sig struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}
+	if !assert.NoError(t, ssh.Unmarshal(blob, &sig)) {
+		return
+	}
+	assert.Equal(t, uint32(1), sig.Version)
+	assert.Equal(t, publicKey.Marshal(), sig.PublicKey)
+	assert.Equal(t, "git", sig.Namespace)
+	assert.Equal(t, "sha512", sig.HashAlgorithm)
+
+	var signature ssh.Signature
+	if !assert.NoError(t, ssh.Unmarshal(sig.Signature, &signature)) {
+		return
+	}
+	hash := sha512.Sum512(message)
+	assert.NoError(t, publicKey.Verify(sshSignedData("git", hash[:]), &signature))
+}
@@ -0,0 +1,84 @@
+package qyt
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// BranchNameData is the data the branch name template is executed with.
+type BranchNameData struct {
+	// Branch is the name of the ref the expression was applied to.
+	Branch string
+	Query  string
+
+	// Values are the values the expression set on $values.
+	Values map[string]string
+}
+
+// newPublishedValues returns the mapping an expression can publish values on
+// as $values. The same mapping is shared by every file on a branch.
+func newPublishedValues() *yqlib.CandidateNode {
+	return &yqlib.CandidateNode{Kind: yqlib.MappingNode, Tag: "!!map"}
+}
+
+func setValuesVariable(ctx *yqlib.Context, values *yqlib.CandidateNode) {
+	if values == nil {
+		return
+	}
+	nodes := list.New()
+	nodes.PushBack(values)
+	ctx.SetVariable("values", nodes)
+}
+
+// publishedValueStrings returns the values an expression set on $values.
+// Scalars are used as they are and other values are encoded as JSON.
+func publishedValueStrings(values *yqlib.CandidateNode) (map[string]string, error) {
+	if values.Kind != yqlib.MappingNode {
+		return nil, fmt.Errorf("$values must be a map: got %s", values.Tag)
+	}
+	result := make(map[string]string, len(values.Content)/2)
+	for i := 0; i+1 < len(values.Content); i += 2 {
+		key, value := values.Content[i], values.Content[i+1]
+		if value.Kind == yqlib.ScalarNode {
+			result[key.Value] = value.Value
+			continue
+		}
+		s, err := compactJSON(value)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode $values.%s: %w", key.Value, err)
+		}
+		result[key.Value] = s
+	}
+	return result, nil
+}
+
+// newBranchTemplate parses the name of the branches apply creates. A name
+// without any actions is a prefix for the name of the branch the expression
+// was applied to.
+func newBranchTemplate(branchName string) (*template.Template, error) {
+	if !strings.Contains(branchName, "{{") {
+		branchName += "{{.Branch}}"
+	}
+	return template.New("branch").Funcs(commitMessageFuncs).Option("missingkey=error").Parse(branchName)
+}
+
+func executeBranchTemplate(branchTemplate *template.Template, data BranchNameData) (plumbing.ReferenceName, error) {
+	var buf strings.Builder
+	if err := branchTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("could not execute branch name template: %w", err)
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("branch name template returned an empty name")
+	}
+	branchName := plumbing.NewBranchReferenceName(name)
+	if err := branchName.Validate(); err != nil {
+		return "", err
+	}
+	return branchName, nil
+}
@@ -0,0 +1,73 @@
+package qyt
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply_published_values(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+	master, masterErr := repo.Head()
+	if !assert.NoError(t, masterErr) {
+		return
+	}
+
+	updates, applyErr := Apply(repo, `.replicas = 3 | $values.replicas = .replicas | $values.names += [.name]`,
+		RefFilter{Branches: "^master$"},
+		FileFilter{Pattern: `deploy/.*\.yml`}, "scale {{.Values.names}} to {{.Values.replicas}}", "scale/{{.Branch}}-{{.Values.replicas}}",
+		someSignature(), someSignature(), nil,
+		1, testing.Verbose(), false,
+	)
+	if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+		return
+	}
+	assert.Equal(t, plumbing.NewBranchReferenceName("scale/master-3"), updates[0].Name)
+	commit, commitErr := repo.CommitObject(updates[0].New)
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+	assert.Equal(t, `scale ["api","web"] to 3`, commit.Message)
+
+	t.Run("join", func(t *testing.T) {
+		updates, applyErr := Apply(repo, `.["deploy/web.yml"].replicas = 4 | $values.replicas = .["deploy/web.yml"].replicas`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`, Join: true}, "{{.Values.replicas}}", "join/{{.Values.replicas}}",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		if !assert.NoError(t, applyErr) || !assert.Len(t, updates, 1) {
+			return
+		}
+		assert.Equal(t, plumbing.NewBranchReferenceName("join/4"), updates[0].Name)
+	})
+
+	t.Run("missing value", func(t *testing.T) {
+		_, applyErr := Apply(repo, `.replicas = 5`,
+			RefFilter{Branches: "^master$"},
+			FileFilter{Pattern: `deploy/.*\.yml`}, "msg", "scale/{{.Values.replicas}}",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, applyErr, "could not execute branch name template")
+	})
+
+	t.Run("same branch name", func(t *testing.T) {
+		if !assert.NoError(t, repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/other", master.Hash()))) {
+			return
+		}
+		_, applyErr := Apply(repo, `.replicas = 6 | $values.replicas = .replicas`,
+			RefFilter{Branches: "^(master|other)$"},
+			FileFilter{Pattern: `deploy/.*\.yml`}, "msg", "scale/{{.Values.replicas}}",
+			someSignature(), someSignature(), nil,
+			1, testing.Verbose(), false,
+		)
+		assert.ErrorContains(t, applyErr, `branches "master" and "other" would both be committed to "scale/6"`)
+		_, refErr := repo.Reference("refs/heads/scale/6", false)
+		assert.Error(t, refErr)
+	})
+}
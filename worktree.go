@@ -0,0 +1,165 @@
+package qyt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mikefarah/yq/v4/pkg/yqlib"
+)
+
+// ApplyWorktree writes the files the expression changes on the checked out
+// commit to the worktree instead of committing them, and returns the changed
+// files. When stage is true the changes are also added to the index. It fails
+// without writing anything when a changed file has uncommitted changes in the
+// worktree or index unless force is true, in which case the expression is
+// evaluated on the files in the worktree so the uncommitted changes are kept.
+// When a file can not be written, the files already written are returned with
+// the error.
+func ApplyWorktree(repo *git.Repository, yqExp string, fileFilter FileFilter, stage, force, verbose bool) ([]FileDiff, error) {
+	yqExpression, err := yqlib.ExpressionParser.ParseExpression(yqExp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse yq expression: %s\n", err)
+	}
+
+	fp, err := fileFilter.compile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file filter: %s\n", err)
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("could not read HEAD: %w", err)
+	}
+
+	commit, files, err := matchingFilesOnBranch(repo, *head, fp)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("could not read worktree status: %w", err)
+	}
+	if force {
+		if files, err = worktreeFiles(wt, status, files); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		lock    sync.Mutex
+		changes []fileChange
+	)
+	if fileFilter.Join {
+		changes, err = rewriteJoinedFiles(&lock, commit, files, yqExpression, fileFilter.Format, refScope(*head), newPublishedValues())
+	} else {
+		changes, err = rewriteFiles(&lock, *head, files, yqExpression, fileFilter.Format, newPublishedValues(), verbose)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !force {
+		var dirty []string
+		for _, change := range changes {
+			if s, ok := status[change.Name]; ok && (s.Staging != git.Unmodified || s.Worktree != git.Unmodified) {
+				dirty = append(dirty, change.Name)
+			}
+		}
+		if len(dirty) > 0 {
+			slices.Sort(dirty)
+			return nil, fmt.Errorf("files with uncommitted changes would be overwritten: %s", strings.Join(dirty, ", "))
+		}
+	}
+
+	// every diff is computed before anything is written so a failure can
+	// only happen while writing
+	slices.SortFunc(changes, func(a, b fileChange) int { return strings.Compare(a.Name, b.Name) })
+	diffs := make([]FileDiff, 0, len(changes))
+	for _, change := range changes {
+		fileDiff, err := changedFileDiff(change, fileFilter.Format)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, fileDiff)
+	}
+
+	for i, change := range changes {
+		if err := writeWorktreeFile(wt, change, stage); err != nil {
+			return diffs[:i], fmt.Errorf("could not write file %q: %w", change.Name, err)
+		}
+	}
+	return diffs, nil
+}
+
+// worktreeFiles replaces the files with uncommitted changes with their
+// contents in the worktree. Files removed from the worktree are dropped.
+func worktreeFiles(wt *git.Worktree, status git.Status, files []*object.File) ([]*object.File, error) {
+	result := make([]*object.File, 0, len(files))
+	for _, file := range files {
+		s, ok := status[file.Name]
+		if !ok || (s.Staging == git.Unmodified && s.Worktree == git.Unmodified) {
+			result = append(result, file)
+			continue
+		}
+		in, err := util.ReadFile(wt.Filesystem, file.Name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read file %q: %w", file.Name, err)
+		}
+		obj := new(plumbing.MemoryObject)
+		obj.SetType(plumbing.BlobObject)
+		if _, err := obj.Write(in); err != nil {
+			return nil, err
+		}
+		blob, err := object.DecodeBlob(obj)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, object.NewFile(file.Name, file.Mode, blob))
+	}
+	return result, nil
+}
+
+// writeWorktreeFile writes or removes the changed file in the worktree and
+// when stage is true, updates the index to match.
+func writeWorktreeFile(wt *git.Worktree, change fileChange, stage bool) error {
+	if change.After == nil {
+		if stage {
+			_, err := wt.Remove(change.Name)
+			return err
+		}
+		if err := wt.Filesystem.Remove(change.Name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	perm := os.FileMode(0o644)
+	if change.Mode == filemode.Executable {
+		perm = 0o755
+	}
+	if err := util.WriteFile(wt.Filesystem, change.Name, change.After, perm); err != nil {
+		return err
+	}
+	if stage {
+		_, err := wt.Add(change.Name)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package qyt
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyWorktree(t *testing.T) {
+	repo := createJoinRepository(t)
+	if repo == nil {
+		return
+	}
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	head, headErr := repo.Head()
+	if !assert.NoError(t, headErr) {
+		return
+	}
+
+	changed, applyErr := ApplyWorktree(repo, `.replicas = 3`, FileFilter{Pattern: `deploy/.*\.yml`}, false, false, testing.Verbose())
+	if !assert.NoError(t, applyErr) || !assert.Len(t, changed, 2) {
+		return
+	}
+	assert.Equal(t, "deploy/api.yml", changed[0].File)
+	assert.Equal(t, DiffModified, changed[0].Status)
+
+	web, readErr := util.ReadFile(wt.Filesystem, "deploy/web.yml")
+	if !assert.NoError(t, readErr) {
+		return
+	}
+	assert.Equal(t, "# web deployment\nname: web\nreplicas: 3\n", string(web))
+
+	status, statusErr := wt.Status()
+	if !assert.NoError(t, statusErr) {
+		return
+	}
+	assert.Equal(t, git.Modified, status.File("deploy/web.yml").Worktree)
+	assert.Equal(t, git.Unmodified, status.File("deploy/web.yml").Staging)
+
+	newHead, headErr := repo.Head()
+	if assert.NoError(t, headErr) {
+		assert.Equal(t, head.Hash(), newHead.Hash(), "it must not create a commit")
+	}
+
+	t.Run("dirty files", func(t *testing.T) {
+		_, applyErr := ApplyWorktree(repo, `.replicas = 4`, FileFilter{Pattern: `deploy/.*\.yml`}, false, false, testing.Verbose())
+		assert.ErrorContains(t, applyErr, "files with uncommitted changes would be overwritten: deploy/api.yml, deploy/web.yml")
+
+		web, readErr := util.ReadFile(wt.Filesystem, "deploy/web.yml")
+		if assert.NoError(t, readErr) {
+			assert.Equal(t, "# web deployment\nname: web\nreplicas: 3\n", string(web))
+		}
+	})
+
+	t.Run("force keeps uncommitted changes", func(t *testing.T) {
+		if !assert.NoError(t, util.WriteFile(wt.Filesystem, "deploy/api.yml", []byte("name: api\nreplicas: 3\nimage: local\n"), 0o644)) {
+			return
+		}
+
+		_, applyErr := ApplyWorktree(repo, `.replicas = 4`, FileFilter{Pattern: `deploy/api\.yml`}, false, true, testing.Verbose())
+		if !assert.NoError(t, applyErr) {
+			return
+		}
+
+		api, readErr := util.ReadFile(wt.Filesystem, "deploy/api.yml")
+		if assert.NoError(t, readErr) {
+			assert.Equal(t, "name: api\nreplicas: 4\nimage: local\n", string(api))
+		}
+	})
+
+	t.Run("force and stage", func(t *testing.T) {
+		_, applyErr := ApplyWorktree(repo, `.replicas = 4`, FileFilter{Pattern: `deploy/.*\.yml`}, true, true, testing.Verbose())
+		if !assert.NoError(t, applyErr) {
+			return
+		}
+
+		status, statusErr := wt.Status()
+		if !assert.NoError(t, statusErr) {
+			return
+		}
+		assert.Equal(t, git.Modified, status.File("deploy/web.yml").Staging)
+		assert.Equal(t, git.Unmodified, status.File("deploy/web.yml").Worktree)
+	})
+
+	t.Run("remove files", func(t *testing.T) {
+		changed, applyErr := ApplyWorktree(repo, `.["services.yml"] = null`, FileFilter{Pattern: `services\.yml`, Join: true}, true, false, testing.Verbose())
+		if !assert.NoError(t, applyErr) || !assert.Len(t, changed, 1) {
+			return
+		}
+		assert.Equal(t, DiffRemoved, changed[0].Status)
+
+		_, statErr := wt.Filesystem.Stat("services.yml")
+		assert.Error(t, statErr)
+		status, statusErr := wt.Status()
+		if assert.NoError(t, statusErr) {
+			assert.Equal(t, git.Deleted, status.File("services.yml").Staging)
+		}
+	})
+}
+
+func TestApplyWorktree_write_fails(t *testing.T) {
+	fs := &failingWriteFilesystem{Filesystem: memfs.New()}
+	repo, initErr := git.Init(memory.NewStorage(), fs)
+	if !assert.NoError(t, initErr) {
+		return
+	}
+	wt, wtErr := repo.Worktree()
+	if !assert.NoError(t, wtErr) {
+		return
+	}
+	for _, name := range []string{"a.yml", "b.yml"} {
+		createFile(t, wt.Filesystem, name, "replicas: 1\n")
+		_, addErr := wt.Add(name)
+		if !assert.NoError(t, addErr) {
+			return
+		}
+	}
+	signature := someSignature()
+	_, commitErr := wt.Commit("add files", &git.CommitOptions{Author: &signature, Committer: &signature})
+	if !assert.NoError(t, commitErr) {
+		return
+	}
+
+	fs.failing = "b.yml"
+	written, applyErr := ApplyWorktree(repo, `.replicas = 2`, FileFilter{Pattern: `.*\.yml`}, false, false, testing.Verbose())
+	assert.ErrorContains(t, applyErr, `could not write file "b.yml"`)
+	if assert.Len(t, written, 1) {
+		assert.Equal(t, "a.yml", written[0].File)
+	}
+}
+
+// failingWriteFilesystem fails to open the failing file for writing.
+type failingWriteFilesystem struct {
+	billy.Filesystem
+	failing string
+}
+
+func (fs *failingWriteFilesystem) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if filename == fs.failing && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, errors.New("write failed")
+	}
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}